@@ -0,0 +1,75 @@
+CREATE EXTERNAL TABLE IF NOT EXISTS `default`.`bedrock_invocation_metadata` (
+  `timestamp` timestamp,
+  `accountId` string,
+  `identity` struct<arn:string>,
+  `identityTags` array<struct<key:string,value:string>>,
+  `resourceTags` array<struct<key:string,value:string>>,
+  `region` string,
+  `requestId` string,
+  `operation` string,
+  `modelId` string,
+  `modelName` string,
+  `modelProvider` string,
+  `provisionedModelArn` string,
+  `pricingMode` string,
+  `inferenceProfileId` string,
+  `inferenceProfileGeography` string,
+  `inputContentType` string,
+  `outputContentType` string,
+  `stopReason` string,
+  `truncated` boolean,
+  `outputCount` bigint,
+  `inferenceParams` struct<maxTokens:bigint,temperature:double,topP:double,topK:bigint,stopSequences:array<string>>,
+  `retainedFields` array<struct<path:string,value:string>>,
+  `promptFingerprint` string,
+  `systemPromptFingerprint` string,
+  `completionFingerprint` string,
+  `inputTokenCount` bigint,
+  `outputTokenCount` bigint,
+  `cacheReadInputTokenCount` bigint,
+  `cacheWriteInputTokenCount` bigint,
+  `inputTokenCostUSD` double,
+  `outputTokenCostUSD` double,
+  `cacheReadInputTokenCostUSD` double,
+  `cacheWriteInputTokenCostUSD` double,
+  `cacheSavingsUSD` double,
+  `pricingUnit` string,
+  `unitCount` double,
+  `unitCostUSD` double,
+  `invocationLatency` bigint,
+  `firstByteLatency` bigint,
+  `energyConsumptionkWh` double,
+  `carbonEmissiongCO2e` double
+)
+PARTITIONED BY (
+  `account` string,
+  `log_region` string,
+  `year` int,
+  `month` int,
+  `day` int,
+  `hour` int
+)
+ROW FORMAT SERDE 'org.openx.data.jsonserde.JsonSerDe'
+STORED AS INPUTFORMAT 'org.apache.hadoop.mapred.TextInputFormat'
+OUTPUTFORMAT 'org.apache.hadoop.hive.ql.io.HiveIgnoreKeyTextOutputFormat'
+LOCATION 's3://METADATA_BUCKET/'
+TBLPROPERTIES (
+  'projection.enabled'='true',
+  'projection.account.type'='enum',
+  'projection.account.values'='ACCOUNT_ID',
+  'projection.log_region.type'='enum',
+  'projection.log_region.values'='REGION',
+  'projection.year.type'='integer',
+  'projection.year.range'='2023,2099',
+  'projection.year.digits'='4',
+  'projection.month.type'='integer',
+  'projection.month.range'='1,12',
+  'projection.month.digits'='2',
+  'projection.day.type'='integer',
+  'projection.day.range'='1,31',
+  'projection.day.digits'='2',
+  'projection.hour.type'='integer',
+  'projection.hour.range'='0,23',
+  'projection.hour.digits'='2',
+  'storage.location.template'='s3://METADATA_BUCKET/account=${account}/region=${log_region}/year=${year}/month=${month}/day=${day}/hour=${hour}/'
+);
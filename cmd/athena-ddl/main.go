@@ -0,0 +1,79 @@
+// Command athena-ddl prints the Athena CREATE EXTERNAL TABLE statement, or the
+// equivalent Glue table input, for the metadata objects written by
+// amazon-bedrock-metadata.
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/greenscale-ai/amazon-bedrock-metadata/pkg/athena"
+	"github.com/greenscale-ai/amazon-bedrock-metadata/pkg/output"
+)
+
+func main() {
+	database := flag.String("database", "default", "Glue database of the table")
+	table := flag.String("table", "bedrock_invocation_metadata", "name of the table")
+	location := flag.String("location", "", "S3 URI of the metadata bucket, including any output prefix")
+	format := flag.String("format", string(output.FormatJSON), "output format of the metadata, json or parquet")
+	layout := flag.String("layout", string(output.LayoutSource), "output key layout of the metadata, source or hive")
+	accounts := flag.String("accounts", "", "comma separated account IDs to project")
+	regions := flag.String("regions", "", "comma separated regions to project")
+	injected := flag.Bool("injected", false, "inject the account and region partitions instead, so every query must filter on both")
+	glue := flag.Bool("glue", false, "print the Glue table input JSON instead of the DDL")
+	flag.Parse()
+
+	if *location == "" {
+		log.Fatal("-location is required")
+	}
+
+	options := athena.TableOptions{
+		Database:           *database,
+		Table:              *table,
+		Location:           *location,
+		Accounts:           splitList(*accounts),
+		Regions:            splitList(*regions),
+		InjectedPartitions: *injected,
+	}
+
+	var err error
+	if options.Format, err = output.ParseFormat(*format); err != nil {
+		log.Fatal(err)
+	}
+	if options.Layout, err = output.ParseLayout(*layout); err != nil {
+		log.Fatal(err)
+	}
+
+	if *glue {
+		tableInput, err := athena.NewGlueTableInput(options)
+		if err != nil {
+			log.Fatal(err)
+		}
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		if err := encoder.Encode(tableInput); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	statement, err := athena.CreateTableStatement(options)
+	if err != nil {
+		log.Fatal(err)
+	}
+	fmt.Print(statement)
+}
+
+func splitList(value string) []string {
+	var values []string
+	for _, v := range strings.Split(value, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			values = append(values, v)
+		}
+	}
+	return values
+}
@@ -1,18 +1,26 @@
 package main
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"github.com/aws/aws-lambda-go/events"
 	"github.com/aws/aws-lambda-go/lambda"
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/iam"
 	"github.com/aws/aws-sdk-go/service/s3"
 	"github.com/greenscale-ai/amazon-bedrock-metadata/pkg/model"
+	"github.com/greenscale-ai/amazon-bedrock-metadata/pkg/output"
 	"github.com/greenscale-ai/amazon-bedrock-metadata/pkg/processor"
+	"github.com/greenscale-ai/amazon-bedrock-metadata/pkg/storage"
+	"github.com/greenscale-ai/amazon-bedrock-metadata/pkg/trigger"
 	"io"
 	"log"
 	"os"
 	"strconv"
+	"strings"
 	"time"
 )
 
@@ -23,12 +31,26 @@ const (
 	modelInvocationLogsInputBucketRegionEnv = "MODEL_INVOCATION_LOGS_INPUT_BUCKET_REGION"
 	metadataLogsOutputBucketEnv             = "METADATA_LOGS_OUTPUT_BUCKET"
 	metadataLogsOutputBucketRegionEnv       = "METADATA_LOGS_OUTPUT_BUCKET_REGION"
+	modelInvocationLogsInputDirEnv          = "MODEL_INVOCATION_LOGS_INPUT_DIR"
+	metadataLogsOutputDirEnv                = "METADATA_LOGS_OUTPUT_DIR"
+	checkpointManifestKeyEnv                = "CHECKPOINT_MANIFEST_KEY"
+	checkpointStateFileEnv                  = "CHECKPOINT_STATE_FILE"
+	outputFormatEnv                         = "OUTPUT_FORMAT"
+	outputKeyLayoutEnv                      = "OUTPUT_KEY_LAYOUT"
+	outputPrefixEnv                         = "OUTPUT_PREFIX"
+	provisionedModelsFileEnv                = "PROVISIONED_MODELS_FILE"
+	redactionPolicyFileEnv                  = "REDACTION_POLICY_FILE"
+	redactionSaltEnv                        = "REDACTION_SALT"
+	fingerprintKeyEnv                       = "FINGERPRINT_KEY"
+	fingerprintNormalizationEnv             = "FINGERPRINT_NORMALIZATION"
 	awsAccountIDEnv                         = "AWS_ACCOUNT_ID"
 	pickLastHourEnv                         = "PICK_LAST_HOUR"
 	yearEnv                                 = "YEAR"
 	monthEnv                                = "MONTH"
 	dayEnv                                  = "DAY"
 	hourEnv                                 = "HOUR"
+	startTimeEnv                            = "START_TIME"
+	endTimeEnv                              = "END_TIME"
 )
 
 var Version = "number missing"
@@ -38,9 +60,11 @@ func main() {
 	log.Println("Starting amazon-bedrock-metadata process, build", Version)
 
 	if runningFromLambda() {
-		lambda.Start(processLogs)
+		lambda.Start(handleEvent)
 	} else {
-		processLogs()
+		if err := processLogs(time.Time{}); err != nil {
+			log.Fatal(err)
+		}
 	}
 }
 
@@ -52,83 +76,223 @@ func runningFromLambda() bool {
 	return false
 }
 
-func processLogs() {
-	modelInvocationLogsInputBucket := os.Getenv(modelInvocationLogsInputBucketEnv)
-	if modelInvocationLogsInputBucket == "" {
-		log.Println("Error: Model Invocation Logs Input S3 bucket name is required.")
-		return
+// handleEvent runs the scheduled batch, or processes exactly the objects referenced
+// by S3 event notifications delivered directly, through SQS or through EventBridge.
+func handleEvent(ctx context.Context, payload json.RawMessage) (*events.SQSEventResponse, error) {
+	invocation, err := trigger.Parse(payload)
+	if err != nil {
+		return nil, err
 	}
 
-	modelInvocationLogsInputBucketPrefix := os.Getenv(modelInvocationLogsInputBucketPrefixEnv)
+	if invocation.Scheduled {
+		return nil, processLogs(invocation.Time)
+	}
+
+	return processObjectEvents(invocation)
+}
 
+func processObjectEvents(invocation *trigger.Invocation) (*events.SQSEventResponse, error) {
 	modelInvocationLogsInputBucketRegion := os.Getenv(modelInvocationLogsInputBucketRegionEnv)
 	if modelInvocationLogsInputBucketRegion == "" {
-		log.Println("Error: Model Invocation Logs Input S3 bucket region is required.")
-		return
+		return nil, errors.New("model invocation logs input S3 bucket region is required")
 	}
 
-	metadataLogsOutputBucket := os.Getenv(metadataLogsOutputBucketEnv)
-	if metadataLogsOutputBucket == "" {
-		log.Println("Error: Metadata Logs Output S3 bucket name is required.")
-		return
+	modelLogsProcessor, err := newProcessor(modelInvocationLogsInputBucketRegion)
+	if err != nil {
+		return nil, err
 	}
 
-	metadataLogsOutputBucketRegion := os.Getenv(metadataLogsOutputBucketRegionEnv)
-	if metadataLogsOutputBucketRegion == "" {
-		log.Println("Error: Metadata Logs Output S3 bucket region is required.")
-		return
+	modelInvocationLogsInputBucket := os.Getenv(modelInvocationLogsInputBucketEnv)
+	var objects []storage.ObjectInfo
+	for _, obj := range invocation.Objects {
+		if modelInvocationLogsInputBucket != "" && obj.Bucket != modelInvocationLogsInputBucket {
+			log.Printf("Skipping object %s from bucket %s, expected bucket %s\n", obj.Key, obj.Bucket, modelInvocationLogsInputBucket)
+			continue
+		}
+		// Bedrock also writes a permission check object next to the logs, and a manifest
+		// next to batch inference output
+		if _, batchOutput := output.BatchJobID(obj.Key); !batchOutput && !strings.Contains(obj.Key, "/BedrockModelInvocationLogs/") {
+			log.Printf("Skipping object %s, not a model invocation log or batch inference output\n", obj.Key)
+			continue
+		}
+		objects = append(objects, storage.ObjectInfo{Key: obj.Key, ETag: obj.ETag})
+	}
+
+	if len(objects) == 0 && len(invocation.InvalidMessageIDs) == 0 {
+		return nil, nil
+	}
+
+	report, err := modelLogsProcessor.ProcessModelInvocationLogObjects(objects)
+	logRunReport(report)
+
+	if !invocation.FromSQS {
+		return nil, err
+	}
+	// Any other error means objects may be unaccounted for, such as an hour that was not
+	// rolled up, so the whole batch is redelivered
+	var objectsFailed *processor.ObjectsFailedError
+	if err != nil && !errors.As(err, &objectsFailed) {
+		return nil, err
+	}
+	if err != nil {
+		log.Println(err)
+	}
+
+	failures := make(map[string]bool)
+	for _, failure := range report.Failures {
+		failures[failure.Key] = true
+	}
+
+	// Report only the SQS messages with failed objects, so the rest are not redelivered
+	response := &events.SQSEventResponse{}
+	failedMessages := make(map[string]bool)
+	for _, messageID := range invocation.InvalidMessageIDs {
+		failedMessages[messageID] = true
+		response.BatchItemFailures = append(response.BatchItemFailures, events.SQSBatchItemFailure{ItemIdentifier: messageID})
+	}
+	for _, obj := range invocation.Objects {
+		if failures[obj.Key] && !failedMessages[obj.MessageID] {
+			failedMessages[obj.MessageID] = true
+			response.BatchItemFailures = append(response.BatchItemFailures, events.SQSBatchItemFailure{ItemIdentifier: obj.MessageID})
+		}
+	}
+	return response, nil
+}
+
+// processLogs runs a batch over the configured hours. With PICK_LAST_HOUR the hour
+// before scheduledTime is processed, or the hour before now when it is zero.
+func processLogs(scheduledTime time.Time) error {
+	modelInvocationLogsInputBucketPrefix := os.Getenv(modelInvocationLogsInputBucketPrefixEnv)
+
+	modelInvocationLogsInputBucketRegion := os.Getenv(modelInvocationLogsInputBucketRegionEnv)
+	if modelInvocationLogsInputBucketRegion == "" {
+		return errors.New("model invocation logs input S3 bucket region is required")
 	}
 
 	awsAccountID := os.Getenv(awsAccountIDEnv)
 	if awsAccountID == "" {
-		log.Println("Error: AWS Account ID is required.")
-		return
+		return errors.New("AWS account ID is required")
 	}
 
+	startTime := os.Getenv(startTimeEnv)
+	endTime := os.Getenv(endTimeEnv)
 	pickLastHour := os.Getenv(pickLastHourEnv) != "" && os.Getenv(pickLastHourEnv) != "false"
 	year, _ := strconv.Atoi(os.Getenv(yearEnv))
 	month, _ := strconv.Atoi(os.Getenv(monthEnv))
 	day, _ := strconv.Atoi(os.Getenv(dayEnv))
 	hour, _ := strconv.Atoi(os.Getenv(hourEnv))
 
-	if !pickLastHour {
+	var err error
+	var start, end time.Time
+	if startTime != "" {
+		// Backfill every hour in the requested date range
+		start, err = parseTime(startTime, false)
+		if err != nil {
+			return fmt.Errorf("invalid start time, %v", err)
+		}
+		end = time.Now().UTC().Truncate(time.Hour)
+		if endTime != "" {
+			end, err = parseTime(endTime, true)
+			if err != nil {
+				return fmt.Errorf("invalid end time, %v", err)
+			}
+		}
+		if !start.Before(end) {
+			return errors.New("start time must be before end time")
+		}
+	} else if !pickLastHour {
 		if year == 0 || year < 1970 {
-			log.Println("Error: Invalid year. Please provide a valid year.")
-			return
+			return errors.New("invalid year, please provide a valid year")
 		}
 		if month < 1 || month > 12 {
-			log.Println("Error: Invalid month. Please provide a value between 1 and 12.")
-			return
+			return errors.New("invalid month, please provide a value between 1 and 12")
 		}
 		if day < 1 || day > 31 {
-			log.Println("Error: Invalid day. Please provide a value between 1 and 31.")
-			return
+			return errors.New("invalid day, please provide a value between 1 and 31")
 		}
 		if hour < 0 || hour > 23 {
-			log.Println("Error: Invalid hour. Please provide a value between 0 and 23.")
-			return
+			return errors.New("invalid hour, please provide a value between 0 and 23")
 		}
+		start = time.Date(year, time.Month(month), day, hour, 0, 0, 0, time.UTC)
+		end = start.Add(time.Hour)
 	} else {
-		// Pick current time
-		now := time.Now()
-		hourAgo := now.Add(-1 * time.Hour)
-		utcNow := hourAgo.In(time.UTC)
+		// Pick the hour before the scheduled event, so delayed or retried invocations
+		// still process the hour they were scheduled for
+		if scheduledTime.IsZero() {
+			scheduledTime = time.Now()
+		}
+		end = scheduledTime.UTC().Truncate(time.Hour)
+		start = end.Add(-1 * time.Hour)
+	}
 
-		year = utcNow.Year()
-		month = int(utcNow.Month())
-		day = utcNow.Day()
-		hour = utcNow.Hour()
+	modelLogsProcessor, err := newProcessor(modelInvocationLogsInputBucketRegion)
+	if err != nil {
+		return err
 	}
 
-	sess, err := session.NewSession(&aws.Config{
-		Region: aws.String(modelInvocationLogsInputBucketRegion),
-	})
-	s3ClientRead := s3.New(sess)
+	var report *processor.RunReport
+	if pickLastHour && startTime == "" && checkpointEnabled() {
+		// Pick up everything new or changed since the last successful run
+		report, err = modelLogsProcessor.ProcessPendingModelInvocationLogs(awsAccountID, modelInvocationLogsInputBucketRegion, modelInvocationLogsInputBucketPrefix, end)
+	} else {
+		report, err = modelLogsProcessor.ProcessModelInvocationLogsInRange(awsAccountID, modelInvocationLogsInputBucketRegion, modelInvocationLogsInputBucketPrefix, start, end)
+	}
+	logRunReport(report)
+	return err
+}
 
-	sess, err = session.NewSession(&aws.Config{
-		Region: aws.String(metadataLogsOutputBucketRegion),
-	})
-	s3ClientWrite := s3.New(sess)
+func logRunReport(report *processor.RunReport) {
+	if report == nil {
+		return
+	}
+
+	for _, summary := range report.Hours {
+		log.Printf("Processed hour %s: %s\n", summary.Hour.Format(time.RFC3339), formatCounts(summary.Counts))
+	}
+	for _, failure := range report.Failures {
+		log.Printf("Failed object %s: %s\n", failure.Key, failure.Error)
+	}
+	log.Printf("Processed run: %s\n", formatCounts(report.Counts))
+}
+
+func formatCounts(counts processor.Counts) string {
+	return fmt.Sprintf("objectsListed=%d objectsSkipped=%d objectsSucceeded=%d objectsFailed=%d linesParsed=%d linesSkipped=%d totalCostUSD=%f",
+		counts.ObjectsListed, counts.ObjectsSkipped, counts.ObjectsSucceeded, counts.ObjectsFailed, counts.LinesParsed, counts.LinesSkipped, counts.TotalCostUSD)
+}
+
+// newProcessor wires up the log source, metadata sink, checkpoint store, output
+// options and metadata generator from the environment.
+func newProcessor(region string) (*processor.Processor, error) {
+	outputFormat, err := output.ParseFormat(os.Getenv(outputFormatEnv))
+	if err != nil {
+		return nil, err
+	}
+
+	outputLayout, err := output.ParseLayout(os.Getenv(outputKeyLayoutEnv))
+	if err != nil {
+		return nil, err
+	}
+
+	outputOptions := output.Options{
+		Format: outputFormat,
+		Layout: outputLayout,
+		Prefix: os.Getenv(outputPrefixEnv),
+	}
+
+	logSource, err := newLogSource(region)
+	if err != nil {
+		return nil, err
+	}
+
+	metadataSink, err := newMetadataSink()
+	if err != nil {
+		return nil, err
+	}
+
+	checkpointStore, err := newCheckpointStore()
+	if err != nil {
+		return nil, err
+	}
 
 	iamSess, err := session.NewSession(&aws.Config{
 		Region: aws.String("us-east-1"),
@@ -140,8 +304,7 @@ func processLogs() {
 	modelsFilePath := fmt.Sprintf("%s/models.json", pwd)
 	modelPriceFile, err := os.Open(modelsFilePath)
 	if err != nil {
-		log.Println(err)
-		return
+		return nil, err
 	}
 
 	defer func(modelPriceFile *os.File) {
@@ -153,26 +316,193 @@ func processLogs() {
 
 	modelsPriceDetails, err := io.ReadAll(modelPriceFile)
 	if err != nil {
-		log.Println(err)
-		return
+		return nil, err
 	}
 
 	modelCostEstimator, err := model.NewCostEstimator(modelsPriceDetails)
 	if err != nil {
-		log.Println(err)
-		return
+		return nil, err
 	}
 
 	// Estimating carbon footprint based on configuration for AWS Inferentia2 instance types and average global carbon intensity
 	modelCarbonFootprint := model.NewCarbonFootprintEstimator(400, 768000, 450)
 
-	modelMetaDataGenerator := model.NewMetadataGenerator(modelCostEstimator, modelCarbonFootprint, identityTagsBuilder)
+	provisionedModels, err := newProvisionedModels()
+	if err != nil {
+		return nil, err
+	}
+
+	redactionPolicy, err := newRedactionPolicy()
+	if err != nil {
+		return nil, err
+	}
+
+	// Prompts and completions are only fingerprinted with a key, so fingerprints can't
+	// be matched against guessed text
+	var fingerprinter *model.Fingerprinter
+	if fingerprintKey := os.Getenv(fingerprintKeyEnv); fingerprintKey != "" {
+		normalization, err := model.ParseNormalization(os.Getenv(fingerprintNormalizationEnv))
+		if err != nil {
+			return nil, err
+		}
+		fingerprinter = model.NewFingerprinter(fingerprintKey, normalization)
+	}
+
+	// Batch inference jobs are looked up in the region of the bucket they write their
+	// output to, and application inference profiles in the region of their ARN
+	bedrockSess, err := session.NewSession(&aws.Config{
+		Region: aws.String(region),
+	})
+	if err != nil {
+		return nil, err
+	}
+	bedrockClient := model.NewBedrockClient(bedrockSess.Config.Credentials, region)
 
-	modelLogsProcessor := processor.NewProcessor(s3ClientRead, s3ClientWrite, modelMetaDataGenerator, modelInvocationLogsInputBucket, metadataLogsOutputBucket)
+	modelMetaDataGenerator := model.NewMetadataGenerator(modelCostEstimator, modelCarbonFootprint, identityTagsBuilder, model.GeneratorOptions{
+		ProvisionedModels: provisionedModels,
+		BatchJobs:         bedrockClient,
+		InferenceProfiles: bedrockClient,
+		ResourceTags:      model.NewResourceTagsBuilder(bedrockSess),
+		Redaction:         redactionPolicy,
+		Fingerprints:      fingerprinter,
+	})
 
-	err = modelLogsProcessor.ProcessModelInvocationLogs(awsAccountID, modelInvocationLogsInputBucketRegion, modelInvocationLogsInputBucketPrefix, year, month, day, hour)
+	return processor.NewProcessor(logSource, metadataSink, checkpointStore, modelMetaDataGenerator, outputOptions), nil
+}
+
+// newProvisionedModels reads the mapping of provisioned model ARNs to base models, if
+// one is configured.
+func newProvisionedModels() (map[string]model.ProvisionedModel, error) {
+	provisionedModelsFile := os.Getenv(provisionedModelsFileEnv)
+	if provisionedModelsFile == "" {
+		return nil, nil
+	}
+
+	provisionedModelDetails, err := os.ReadFile(provisionedModelsFile)
 	if err != nil {
-		log.Println(err)
-		return
+		return nil, err
+	}
+
+	provisionedModels, err := model.NewProvisionedModels(provisionedModelDetails)
+	if err != nil {
+		return nil, fmt.Errorf("invalid provisioned models file %q, %v", provisionedModelsFile, err)
+	}
+	return provisionedModels, nil
+}
+
+// newRedactionPolicy reads the redaction policy, if one is configured. The salt of
+// hash rules may be set in the environment rather than in the policy file.
+func newRedactionPolicy() (*model.RedactionPolicy, error) {
+	redactionPolicyFile := os.Getenv(redactionPolicyFileEnv)
+	if redactionPolicyFile == "" {
+		return nil, nil
+	}
+
+	redactionPolicyDetails, err := os.ReadFile(redactionPolicyFile)
+	if err != nil {
+		return nil, err
+	}
+
+	redactionPolicy, err := model.NewRedactionPolicy(redactionPolicyDetails, os.Getenv(redactionSaltEnv))
+	if err != nil {
+		return nil, fmt.Errorf("invalid redaction policy file %q, %v", redactionPolicyFile, err)
+	}
+	return redactionPolicy, nil
+}
+
+// parseTime parses a backfill boundary given either as RFC3339 or as a UTC date
+// (2006-01-02) or date and hour (2006-01-02T15). A date-only end boundary
+// includes that whole day.
+func parseTime(value string, isEnd bool) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	if t, err := time.Parse("2006-01-02T15", value); err == nil {
+		return t, nil
+	}
+	t, err := time.Parse("2006-01-02", value)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("%q is not an RFC3339 time or a 2006-01-02 date", value)
+	}
+	if isEnd {
+		t = t.AddDate(0, 0, 1)
+	}
+	return t, nil
+}
+
+// newLogSource reads model invocation logs from a local directory when one is
+// configured, and from the input S3 bucket otherwise.
+func newLogSource(region string) (storage.LogSource, error) {
+	if inputDir := os.Getenv(modelInvocationLogsInputDirEnv); inputDir != "" {
+		return storage.NewLocalLogSource(inputDir), nil
+	}
+
+	modelInvocationLogsInputBucket := os.Getenv(modelInvocationLogsInputBucketEnv)
+	if modelInvocationLogsInputBucket == "" {
+		return nil, errors.New("model invocation logs input S3 bucket name is required")
+	}
+
+	sess, err := session.NewSession(&aws.Config{
+		Region: aws.String(region),
+	})
+	if err != nil {
+		return nil, err
+	}
+	return storage.NewS3LogSource(s3.New(sess), modelInvocationLogsInputBucket), nil
+}
+
+// newMetadataSink writes metadata to a local directory when one is configured,
+// and to the output S3 bucket otherwise.
+func newMetadataSink() (storage.MetadataSink, error) {
+	if outputDir := os.Getenv(metadataLogsOutputDirEnv); outputDir != "" {
+		return storage.NewLocalMetadataSink(outputDir), nil
+	}
+
+	metadataLogsOutputBucket := os.Getenv(metadataLogsOutputBucketEnv)
+	if metadataLogsOutputBucket == "" {
+		return nil, errors.New("metadata logs output S3 bucket name is required")
+	}
+
+	metadataLogsOutputBucketRegion := os.Getenv(metadataLogsOutputBucketRegionEnv)
+	if metadataLogsOutputBucketRegion == "" {
+		return nil, errors.New("metadata logs output S3 bucket region is required")
+	}
+
+	sess, err := session.NewSession(&aws.Config{
+		Region: aws.String(metadataLogsOutputBucketRegion),
+	})
+	if err != nil {
+		return nil, err
+	}
+	return storage.NewS3MetadataSink(s3.New(sess), metadataLogsOutputBucket), nil
+}
+
+func checkpointEnabled() bool {
+	return os.Getenv(checkpointStateFileEnv) != "" || os.Getenv(checkpointManifestKeyEnv) != ""
+}
+
+// newCheckpointStore keeps the checkpoint in a local state file or as a manifest
+// object in the output S3 bucket. Without either, checkpointing is disabled.
+func newCheckpointStore() (storage.CheckpointStore, error) {
+	if stateFile := os.Getenv(checkpointStateFileEnv); stateFile != "" {
+		return storage.NewLocalCheckpointStore(stateFile), nil
+	}
+
+	manifestKey := os.Getenv(checkpointManifestKeyEnv)
+	if manifestKey == "" {
+		return nil, nil
+	}
+
+	metadataLogsOutputBucket := os.Getenv(metadataLogsOutputBucketEnv)
+	if metadataLogsOutputBucket == "" {
+		return nil, errors.New("metadata logs output S3 bucket name is required to store the checkpoint manifest")
+	}
+
+	sess, err := session.NewSession(&aws.Config{
+		Region: aws.String(os.Getenv(metadataLogsOutputBucketRegionEnv)),
+	})
+	if err != nil {
+		return nil, err
 	}
+	return storage.NewS3CheckpointStore(s3.New(sess), metadataLogsOutputBucket, manifestKey), nil
 }
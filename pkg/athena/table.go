@@ -0,0 +1,303 @@
+package athena
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/greenscale-ai/amazon-bedrock-metadata/pkg/model"
+	"github.com/greenscale-ai/amazon-bedrock-metadata/pkg/output"
+)
+
+// Column is a table column with its Hive type
+type Column struct {
+	Name string `json:"Name"`
+	Type string `json:"Type"`
+}
+
+// TableOptions describes the table over the metadata objects
+type TableOptions struct {
+	Database string
+	Table    string
+	// Location is the S3 URI of the metadata objects, including any output prefix
+	Location string
+	Format   output.Format
+	Layout   output.Layout
+	// Accounts and Regions are the values of the account and log_region partitions,
+	// projected as enums. With InjectedPartitions they are injected instead, and Athena
+	// rejects any query without an equality filter on both.
+	Accounts           []string
+	Regions            []string
+	InjectedPartitions bool
+	// FirstYear is the first year projected
+	FirstYear int
+}
+
+// partitionKeys in the order they appear in the object keys of both layouts. Hive
+// rejects partition keys named like a column, so the region partition is log_region.
+var partitionKeys = []Column{
+	{Name: "account", Type: "string"},
+	{Name: "log_region", Type: "string"},
+	{Name: "year", Type: "int"},
+	{Name: "month", Type: "int"},
+	{Name: "day", Type: "int"},
+	{Name: "hour", Type: "int"},
+}
+
+var timeType = reflect.TypeOf(time.Time{})
+
+// Columns returns the table columns of InvocationLogMetadata, named after its json tags
+func Columns() ([]Column, error) {
+	return structColumns(reflect.TypeOf(model.InvocationLogMetadata{}))
+}
+
+func structColumns(structType reflect.Type) ([]Column, error) {
+	var columns []Column
+	for i := 0; i < structType.NumField(); i++ {
+		field := structType.Field(i)
+		name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+		if !field.IsExported() || name == "-" {
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+
+		columnType, err := hiveType(field.Type)
+		if err != nil {
+			return nil, fmt.Errorf("unable to map field %s, %v", field.Name, err)
+		}
+		columns = append(columns, Column{Name: name, Type: columnType})
+	}
+	return columns, nil
+}
+
+func hiveType(fieldType reflect.Type) (string, error) {
+	if fieldType == timeType {
+		return "timestamp", nil
+	}
+
+	switch fieldType.Kind() {
+	case reflect.String:
+		return "string", nil
+	case reflect.Bool:
+		return "boolean", nil
+	case reflect.Int, reflect.Int64:
+		return "bigint", nil
+	case reflect.Int32:
+		return "int", nil
+	case reflect.Float32, reflect.Float64:
+		return "double", nil
+	case reflect.Pointer:
+		return hiveType(fieldType.Elem())
+	case reflect.Slice:
+		elemType, err := hiveType(fieldType.Elem())
+		if err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("array<%s>", elemType), nil
+	case reflect.Map:
+		valueType, err := hiveType(fieldType.Elem())
+		if err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("map<string,%s>", valueType), nil
+	case reflect.Struct:
+		columns, err := structColumns(fieldType)
+		if err != nil {
+			return "", err
+		}
+		fields := make([]string, len(columns))
+		for i, column := range columns {
+			fields[i] = column.Name + ":" + column.Type
+		}
+		return fmt.Sprintf("struct<%s>", strings.Join(fields, ",")), nil
+	default:
+		return "", fmt.Errorf("unsupported type %s", fieldType)
+	}
+}
+
+type storageFormat struct {
+	classification string
+	serde          string
+	inputFormat    string
+	outputFormat   string
+}
+
+func (o TableOptions) storageFormat() storageFormat {
+	if o.Format == output.FormatParquet {
+		return storageFormat{
+			classification: "parquet",
+			serde:          "org.apache.hadoop.hive.ql.io.parquet.serde.ParquetHiveSerDe",
+			inputFormat:    "org.apache.hadoop.hive.ql.io.parquet.MapredParquetInputFormat",
+			outputFormat:   "org.apache.hadoop.hive.ql.io.parquet.MapredParquetOutputFormat",
+		}
+	}
+	return storageFormat{
+		classification: "json",
+		serde:          "org.openx.data.jsonserde.JsonSerDe",
+		inputFormat:    "org.apache.hadoop.mapred.TextInputFormat",
+		outputFormat:   "org.apache.hadoop.hive.ql.io.HiveIgnoreKeyTextOutputFormat",
+	}
+}
+
+func (o TableOptions) location() string {
+	return strings.TrimSuffix(o.Location, "/") + "/"
+}
+
+// locationTemplate is the partition projection template matching the key layout
+func (o TableOptions) locationTemplate() string {
+	if o.Layout == output.LayoutHive {
+		return o.location() + "account=${account}/region=${log_region}/year=${year}/month=${month}/day=${day}/hour=${hour}/"
+	}
+	return o.location() + "AWSLogs/${account}/BedrockModelInvocationLogs/${log_region}/${year}/${month}/${day}/${hour}/"
+}
+
+func (o TableOptions) validate() error {
+	if !o.InjectedPartitions && (len(o.Accounts) == 0 || len(o.Regions) == 0) {
+		return errors.New("accounts and regions are required to project the account and log_region partitions, unless they are injected")
+	}
+	return nil
+}
+
+// tableProperties returns the partition projection properties as ordered key/value pairs
+func (o TableOptions) tableProperties() [][2]string {
+	properties := [][2]string{{"projection.enabled", "true"}}
+
+	for _, enum := range []struct {
+		name   string
+		values []string
+	}{{"account", o.Accounts}, {"log_region", o.Regions}} {
+		if o.InjectedPartitions {
+			properties = append(properties, [2]string{"projection." + enum.name + ".type", "injected"})
+		} else {
+			properties = append(properties,
+				[2]string{"projection." + enum.name + ".type", "enum"},
+				[2]string{"projection." + enum.name + ".values", strings.Join(enum.values, ",")},
+			)
+		}
+	}
+
+	firstYear := o.FirstYear
+	if firstYear == 0 {
+		firstYear = 2023
+	}
+
+	for _, integer := range []struct {
+		name   string
+		from   int
+		to     int
+		digits int
+	}{{"year", firstYear, 2099, 4}, {"month", 1, 12, 2}, {"day", 1, 31, 2}, {"hour", 0, 23, 2}} {
+		properties = append(properties,
+			[2]string{"projection." + integer.name + ".type", "integer"},
+			[2]string{"projection." + integer.name + ".range", fmt.Sprintf("%d,%d", integer.from, integer.to)},
+			[2]string{"projection." + integer.name + ".digits", fmt.Sprintf("%d", integer.digits)},
+		)
+	}
+
+	properties = append(properties, [2]string{"storage.location.template", o.locationTemplate()})
+	if o.Format == output.FormatParquet {
+		properties = append(properties, [2]string{"parquet.compression", "SNAPPY"})
+	}
+	return properties
+}
+
+// CreateTableStatement returns the Athena CREATE EXTERNAL TABLE statement for the
+// metadata objects
+func CreateTableStatement(options TableOptions) (string, error) {
+	if err := options.validate(); err != nil {
+		return "", err
+	}
+
+	columns, err := Columns()
+	if err != nil {
+		return "", err
+	}
+
+	var statement strings.Builder
+	fmt.Fprintf(&statement, "CREATE EXTERNAL TABLE IF NOT EXISTS `%s`.`%s` (\n", options.Database, options.Table)
+	writeColumns(&statement, columns)
+	statement.WriteString(")\nPARTITIONED BY (\n")
+	writeColumns(&statement, partitionKeys)
+	statement.WriteString(")\n")
+
+	format := options.storageFormat()
+	fmt.Fprintf(&statement, "ROW FORMAT SERDE '%s'\n", format.serde)
+	fmt.Fprintf(&statement, "STORED AS INPUTFORMAT '%s'\n", format.inputFormat)
+	fmt.Fprintf(&statement, "OUTPUTFORMAT '%s'\n", format.outputFormat)
+	fmt.Fprintf(&statement, "LOCATION '%s'\n", options.location())
+	statement.WriteString("TBLPROPERTIES (\n")
+	properties := options.tableProperties()
+	for i, property := range properties {
+		separator := ","
+		if i == len(properties)-1 {
+			separator = ""
+		}
+		fmt.Fprintf(&statement, "  '%s'='%s'%s\n", property[0], property[1], separator)
+	}
+	statement.WriteString(");\n")
+
+	return statement.String(), nil
+}
+
+func writeColumns(statement *strings.Builder, columns []Column) {
+	for i, column := range columns {
+		separator := ","
+		if i == len(columns)-1 {
+			separator = ""
+		}
+		fmt.Fprintf(statement, "  `%s` %s%s\n", column.Name, column.Type, separator)
+	}
+}
+
+// GlueTableInput mirrors the Glue CreateTable TableInput structure, so it can be
+// passed to `aws glue create-table --table-input`
+type GlueTableInput struct {
+	Name              string            `json:"Name"`
+	TableType         string            `json:"TableType"`
+	Parameters        map[string]string `json:"Parameters"`
+	PartitionKeys     []Column          `json:"PartitionKeys"`
+	StorageDescriptor struct {
+		Columns      []Column `json:"Columns"`
+		Location     string   `json:"Location"`
+		InputFormat  string   `json:"InputFormat"`
+		OutputFormat string   `json:"OutputFormat"`
+		SerdeInfo    struct {
+			SerializationLibrary string `json:"SerializationLibrary"`
+		} `json:"SerdeInfo"`
+	} `json:"StorageDescriptor"`
+}
+
+// NewGlueTableInput returns the Glue table equivalent to CreateTableStatement
+func NewGlueTableInput(options TableOptions) (*GlueTableInput, error) {
+	if err := options.validate(); err != nil {
+		return nil, err
+	}
+
+	columns, err := Columns()
+	if err != nil {
+		return nil, err
+	}
+
+	format := options.storageFormat()
+	table := &GlueTableInput{
+		Name:          options.Table,
+		TableType:     "EXTERNAL_TABLE",
+		Parameters:    map[string]string{"EXTERNAL": "TRUE", "classification": format.classification},
+		PartitionKeys: partitionKeys,
+	}
+	for _, property := range options.tableProperties() {
+		table.Parameters[property[0]] = property[1]
+	}
+
+	table.StorageDescriptor.Columns = columns
+	table.StorageDescriptor.Location = options.location()
+	table.StorageDescriptor.InputFormat = format.inputFormat
+	table.StorageDescriptor.OutputFormat = format.outputFormat
+	table.StorageDescriptor.SerdeInfo.SerializationLibrary = format.serde
+
+	return table, nil
+}
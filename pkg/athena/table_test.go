@@ -0,0 +1,147 @@
+package athena
+
+import (
+	"encoding/json"
+	"flag"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/greenscale-ai/amazon-bedrock-metadata/pkg/model"
+	"github.com/greenscale-ai/amazon-bedrock-metadata/pkg/output"
+)
+
+var update = flag.Bool("update", false, "rewrite the table definitions in the athena directory")
+
+// tableDir holds the table definitions shipped with the project
+const tableDir = "../../athena"
+
+var tableFiles = []struct {
+	name   string
+	format output.Format
+	glue   bool
+}{
+	{name: "invocation_log_metadata_json.sql", format: output.FormatJSON},
+	{name: "invocation_log_metadata_json.glue.json", format: output.FormatJSON, glue: true},
+	{name: "invocation_log_metadata_parquet.sql", format: output.FormatParquet},
+	{name: "invocation_log_metadata_parquet.glue.json", format: output.FormatParquet, glue: true},
+}
+
+func generate(t *testing.T, format output.Format, glue bool) []byte {
+	t.Helper()
+
+	options := TableOptions{
+		Database: "default",
+		Table:    "bedrock_invocation_metadata",
+		Location: "s3://METADATA_BUCKET/",
+		Format:   format,
+		Layout:   output.LayoutHive,
+		Accounts: []string{"ACCOUNT_ID"},
+		Regions:  []string{"REGION"},
+	}
+
+	if !glue {
+		statement, err := CreateTableStatement(options)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return []byte(statement)
+	}
+
+	table, err := NewGlueTableInput(options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := json.MarshalIndent(table, "", "  ")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return append(data, '\n')
+}
+
+// TestTableDefinitionsInSync fails when InvocationLogMetadata changes without the
+// shipped table definitions. Run `go test ./pkg/athena -update` to regenerate them.
+func TestTableDefinitionsInSync(t *testing.T) {
+	for _, file := range tableFiles {
+		generated := generate(t, file.format, file.glue)
+		path := filepath.Join(tableDir, file.name)
+
+		if *update {
+			if err := os.WriteFile(path, generated, 0644); err != nil {
+				t.Fatal(err)
+			}
+			continue
+		}
+
+		shipped, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(shipped) != string(generated) {
+			t.Errorf("%s is out of date with InvocationLogMetadata, run `go test ./pkg/athena -update`", path)
+		}
+	}
+}
+
+func TestColumnsMatchParquetSchema(t *testing.T) {
+	metadataType := reflect.TypeOf(model.InvocationLogMetadata{})
+	for i := 0; i < metadataType.NumField(); i++ {
+		field := metadataType.Field(i)
+		jsonName, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+		parquetName, _, _ := strings.Cut(field.Tag.Get("parquet"), ",")
+		if jsonName != parquetName {
+			t.Errorf("%s: got parquet column %q, wanted %q", field.Name, parquetName, jsonName)
+		}
+	}
+}
+
+func TestColumnsUnsupportedType(t *testing.T) {
+	type unsupported struct {
+		Body any `json:"body"`
+	}
+
+	if _, err := structColumns(reflect.TypeOf(unsupported{})); err == nil {
+		t.Errorf("expected an error for an interface field")
+	}
+}
+
+func TestLocationTemplate(t *testing.T) {
+	options := TableOptions{Location: "s3://bucket/metadata", Layout: output.LayoutSource}
+
+	wanted := "s3://bucket/metadata/AWSLogs/${account}/BedrockModelInvocationLogs/${log_region}/${year}/${month}/${day}/${hour}/"
+	if got := options.locationTemplate(); got != wanted {
+		t.Errorf("got %q, wanted %q", got, wanted)
+	}
+}
+
+func TestPartitionProjection(t *testing.T) {
+	options := TableOptions{Location: "s3://bucket/", Layout: output.LayoutHive}
+	if _, err := CreateTableStatement(options); err == nil {
+		t.Errorf("expected an error without accounts and regions")
+	}
+	if _, err := NewGlueTableInput(options); err == nil {
+		t.Errorf("expected an error without accounts and regions")
+	}
+
+	options.InjectedPartitions = true
+	table, err := NewGlueTableInput(options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := table.Parameters["projection.account.type"]; got != "injected" {
+		t.Errorf("got %q, wanted %q", got, "injected")
+	}
+
+	options = TableOptions{Location: "s3://bucket/", Layout: output.LayoutHive, Accounts: []string{"111111111111", "222222222222"}, Regions: []string{"us-east-1"}}
+	if table, err = NewGlueTableInput(options); err != nil {
+		t.Fatal(err)
+	}
+	if got := table.Parameters["projection.account.values"]; got != "111111111111,222222222222" {
+		t.Errorf("got %q, wanted %q", got, "111111111111,222222222222")
+	}
+	if got := table.Parameters["projection.log_region.type"]; got != "enum" {
+		t.Errorf("got %q, wanted %q", got, "enum")
+	}
+}
@@ -0,0 +1,116 @@
+package model
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/arn"
+)
+
+// OperationBatchInference is the operation of metadata generated from batch inference
+// output records
+const OperationBatchInference = "ModelInvocationJob"
+
+// BatchJob is a batch inference job, as returned by the Bedrock GetModelInvocationJob API
+type BatchJob struct {
+	JobArn     string    `json:"jobArn"`
+	ModelID    string    `json:"modelId"`
+	RoleArn    string    `json:"roleArn"`
+	SubmitTime time.Time `json:"submitTime"`
+	EndTime    time.Time `json:"endTime"`
+}
+
+// Location returns the account and region of the job, from its ARN
+func (j *BatchJob) Location() (accountID, region string, err error) {
+	jobARN, err := arn.Parse(j.JobArn)
+	if err != nil {
+		return "", "", fmt.Errorf("invalid batch inference job ARN %q, %v", j.JobArn, err)
+	}
+	return jobARN.AccountID, jobARN.Region, nil
+}
+
+// Time returns when the job ended, or when it was submitted if it has not ended
+func (j *BatchJob) Time() time.Time {
+	if j.EndTime.IsZero() {
+		return j.SubmitTime
+	}
+	return j.EndTime
+}
+
+// BatchJobResolver looks up batch inference jobs by job ID
+type BatchJobResolver interface {
+	GetBatchJob(jobID string) (*BatchJob, error)
+}
+
+// BatchInferenceRecord is a line of a batch inference job output (.jsonl.out) file
+type BatchInferenceRecord struct {
+	RecordID    string `json:"recordId"`
+	ModelInput  any    `json:"modelInput"`
+	ModelOutput any    `json:"modelOutput"`
+	Error       *struct {
+		ErrorCode    int    `json:"errorCode"`
+		ErrorMessage string `json:"errorMessage"`
+	} `json:"error"`
+}
+
+// NewBatchInferenceInvocationLog converts a batch inference output record into an
+// invocation log, so it gets the same metadata as on-demand invocations. Records are
+// dated by the end of their job and attributed to the job's service role.
+func NewBatchInferenceInvocationLog(job *BatchJob, record *BatchInferenceRecord) (*InvocationLog, error) {
+	if record.Error != nil {
+		return nil, fmt.Errorf("batch inference record %s failed with %d, %s", record.RecordID, record.Error.ErrorCode, record.Error.ErrorMessage)
+	}
+	if record.ModelOutput == nil {
+		return nil, fmt.Errorf("batch inference record %s has no model output", record.RecordID)
+	}
+
+	accountID, region, err := job.Location()
+	if err != nil {
+		return nil, err
+	}
+
+	invocationLog := &InvocationLog{
+		Timestamp:   job.Time(),
+		AccountID:   accountID,
+		Identity:    Identity{Arn: job.RoleArn},
+		Region:      region,
+		RequestID:   record.RecordID,
+		Operation:   OperationBatchInference,
+		ModelID:     job.ModelID,
+		PricingMode: PricingModeBatch,
+	}
+
+	// Token counts are taken from the model output when generating the metadata
+	invocationLog.Input.InputContentType = "application/json"
+	invocationLog.Input.InputBodyJSON = record.ModelInput
+	invocationLog.Output.OutputContentType = "application/json"
+	invocationLog.Output.OutputBodyJSON = record.ModelOutput
+
+	return invocationLog, nil
+}
+
+// BatchJob returns the batch inference job with the given ID
+func (m *MetadataGenerator) BatchJob(jobID string) (*BatchJob, error) {
+	if m.batchJobs == nil {
+		return nil, errors.New("no batch inference job resolver is configured")
+	}
+	return m.batchJobs.GetBatchJob(jobID)
+}
+
+// GenerateBatchInferenceRecordMetadata generates the metadata of a record of the
+// given batch inference job
+func (m *MetadataGenerator) GenerateBatchInferenceRecordMetadata(jobID string, record *BatchInferenceRecord) (*InvocationLogMetadata, error) {
+	job, err := m.BatchJob(jobID)
+	if err != nil {
+		return nil, err
+	}
+
+	invocationLog, err := NewBatchInferenceInvocationLog(job, record)
+	if err != nil {
+		return nil, err
+	}
+	// Record IDs are only unique within their job
+	invocationLog.RequestID = jobID + "/" + record.RecordID
+	return m.GenerateModelInvocationLogMetadata(invocationLog)
+}
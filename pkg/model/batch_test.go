@@ -0,0 +1,138 @@
+package model
+
+import (
+	"encoding/json"
+	"errors"
+	"math"
+	"testing"
+	"time"
+)
+
+type fakeBatchJobResolver map[string]*BatchJob
+
+func (f fakeBatchJobResolver) GetBatchJob(jobID string) (*BatchJob, error) {
+	job, ok := f[jobID]
+	if !ok {
+		return nil, errors.New("job not found")
+	}
+	return job, nil
+}
+
+var testBatchJobs = fakeBatchJobResolver{
+	"haiku-job": {
+		JobArn:     "arn:aws:bedrock:us-east-1:893487256304:model-invocation-job/haiku-job",
+		ModelID:    "anthropic.claude-3-haiku-20240307-v1:0",
+		RoleArn:    "arn:aws:iam::893487256304:role/batch-role",
+		SubmitTime: time.Date(2024, 5, 1, 8, 0, 0, 0, time.UTC),
+		EndTime:    time.Date(2024, 5, 1, 10, 42, 0, 0, time.UTC),
+	},
+	"titan-job": {
+		JobArn:     "arn:aws:bedrock:us-east-1:893487256304:model-invocation-job/titan-job",
+		ModelID:    "amazon.titan-text-express-v1",
+		RoleArn:    "arn:aws:iam::893487256304:role/batch-role",
+		SubmitTime: time.Date(2024, 5, 1, 8, 0, 0, 0, time.UTC),
+	},
+}
+
+func parseBatchInferenceRecord(t *testing.T, line string) *BatchInferenceRecord {
+	t.Helper()
+
+	var record BatchInferenceRecord
+	if err := json.Unmarshal([]byte(line), &record); err != nil {
+		t.Fatal(err)
+	}
+	return &record
+}
+
+func TestMetadataGenerator_GenerateBatchInferenceRecordMetadata(t *testing.T) {
+	modelMetaDataGenerator := newTestGenerator(t, GeneratorOptions{BatchJobs: testBatchJobs}, "role:batch-role")
+
+	record := parseBatchInferenceRecord(t, `{"recordId": "CALL0000001", "modelInput": {"anthropic_version": "bedrock-2023-05-31", "max_tokens": 100, "messages": [{"role": "user", "content": "Hello"}]}, "modelOutput": {"type": "message", "stop_reason": "end_turn", "usage": {"input_tokens": 2000, "output_tokens": 400}}}`)
+
+	metadata, err := modelMetaDataGenerator.GenerateBatchInferenceRecordMetadata("haiku-job", record)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if metadata.PricingMode != PricingModeBatch {
+		t.Errorf("got %q, wanted %q", metadata.PricingMode, PricingModeBatch)
+	}
+	if metadata.RequestID != "haiku-job/CALL0000001" {
+		t.Errorf("got %q, wanted %q", metadata.RequestID, "haiku-job/CALL0000001")
+	}
+	if metadata.AccountID != "893487256304" || metadata.Region != "us-east-1" {
+		t.Errorf("got %s %s, wanted the account and region of the job", metadata.AccountID, metadata.Region)
+	}
+	if !metadata.Timestamp.Equal(time.Date(2024, 5, 1, 10, 42, 0, 0, time.UTC)) {
+		t.Errorf("got %s, wanted the end time of the job", metadata.Timestamp)
+	}
+	if metadata.Operation != OperationBatchInference {
+		t.Errorf("got %q, wanted %q", metadata.Operation, OperationBatchInference)
+	}
+
+	// Batch prices are half the on-demand prices
+	if math.Abs(metadata.InputTokenCostUSD-0.00025) > 1e-12 {
+		t.Errorf("got %f, wanted %f", metadata.InputTokenCostUSD, 0.00025)
+	}
+	if math.Abs(metadata.OutputTokenCostUSD-0.00025) > 1e-12 {
+		t.Errorf("got %f, wanted %f", metadata.OutputTokenCostUSD, 0.00025)
+	}
+}
+
+func TestMetadataGenerator_GenerateBatchInferenceRecordMetadataOnDemandFallback(t *testing.T) {
+	modelMetaDataGenerator := newTestGenerator(t, GeneratorOptions{BatchJobs: testBatchJobs}, "role:batch-role")
+
+	record := parseBatchInferenceRecord(t, `{"recordId": "CALL0000002", "modelInput": {"inputText": "Hello"}, "modelOutput": {"inputTextTokenCount": 1000, "results": [{"tokenCount": 1000, "outputText": "Hi", "completionReason": "FINISH"}]}}`)
+
+	metadata, err := modelMetaDataGenerator.GenerateBatchInferenceRecordMetadata("titan-job", record)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if metadata.InputTokenCount != 1000 || metadata.OutputTokenCount != 1000 {
+		t.Errorf("got %d and %d tokens, wanted %d and %d", metadata.InputTokenCount, metadata.OutputTokenCount, 1000, 1000)
+	}
+	if !metadata.Timestamp.Equal(time.Date(2024, 5, 1, 8, 0, 0, 0, time.UTC)) {
+		t.Errorf("got %s, wanted the submit time of a job that has not ended", metadata.Timestamp)
+	}
+	if metadata.PricingMode != PricingModeBatch {
+		t.Errorf("got %q, wanted %q", metadata.PricingMode, PricingModeBatch)
+	}
+	if metadata.InputTokenCostUSD != 0.0008 || metadata.OutputTokenCostUSD != 0.0016 {
+		t.Errorf("got %f and %f, wanted the on-demand prices", metadata.InputTokenCostUSD, metadata.OutputTokenCostUSD)
+	}
+}
+
+func TestMetadataGenerator_GenerateBatchInferenceRecordMetadataErrors(t *testing.T) {
+	modelMetaDataGenerator := newTestGenerator(t, GeneratorOptions{BatchJobs: testBatchJobs}, "role:batch-role")
+
+	failed := parseBatchInferenceRecord(t, `{"recordId": "CALL0000003", "modelInput": {}, "error": {"errorCode": 400, "errorMessage": "Malformed input request"}}`)
+	if _, err := modelMetaDataGenerator.GenerateBatchInferenceRecordMetadata("haiku-job", failed); err == nil {
+		t.Errorf("expected an error for a failed record")
+	}
+
+	record := parseBatchInferenceRecord(t, `{"recordId": "CALL0000004", "modelInput": {}, "modelOutput": {}}`)
+	if _, err := modelMetaDataGenerator.GenerateBatchInferenceRecordMetadata("unknown-job", record); err == nil {
+		t.Errorf("expected an error for an unknown job")
+	}
+
+	withoutResolver := NewMetadataGenerator(modelMetaDataGenerator.modelCost, modelMetaDataGenerator.carbonFootprint, modelMetaDataGenerator.identity, GeneratorOptions{})
+	if _, err := withoutResolver.GenerateBatchInferenceRecordMetadata("haiku-job", record); err == nil {
+		t.Errorf("expected an error without a batch job resolver")
+	}
+}
+
+func TestNewCostEstimatorPricingMode(t *testing.T) {
+	valid := `{"m": {"cost": [
+		{"region": "us-east-1", "input_cost_per_1k_tokens": 0.002},
+		{"region": "us-east-1", "pricing_mode": "batch", "input_cost_per_1k_tokens": 0.001}
+	]}}`
+	if _, err := NewCostEstimator([]byte(valid)); err != nil {
+		t.Errorf("got %v, wanted batch and on-demand prices in the same region to be valid", err)
+	}
+
+	invalid := `{"m": {"cost": [{"region": "us-east-1", "pricing_mode": "spot"}]}}`
+	if _, err := NewCostEstimator([]byte(invalid)); err == nil {
+		t.Errorf("expected an error for an unsupported pricing mode")
+	}
+}
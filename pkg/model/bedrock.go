@@ -0,0 +1,104 @@
+package model
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	v4 "github.com/aws/aws-sdk-go/aws/signer/v4"
+)
+
+// BedrockClient gets batch inference jobs and inference profiles from the Bedrock API
+// and caches them, failures included, for the run. The API is called directly, since
+// the AWS SDK for Go v1 has no client for these operations.
+type BedrockClient struct {
+	credentials *credentials.Credentials
+	region      string
+	httpClient  *http.Client
+
+	jobs     onceCache[*BatchJob]
+	profiles onceCache[*InferenceProfile]
+}
+
+// NewBedrockClient creates a client for the Bedrock API. region is used for requests
+// that don't name a resource ARN with its own region.
+func NewBedrockClient(credentials *credentials.Credentials, region string) *BedrockClient {
+	return &BedrockClient{
+		credentials: credentials,
+		region:      region,
+		httpClient:  &http.Client{Timeout: 30 * time.Second},
+	}
+}
+
+func (b *BedrockClient) GetBatchJob(jobID string) (*BatchJob, error) {
+	return b.jobs.get(jobID, func() (*BatchJob, error) {
+		var job BatchJob
+		if err := b.get(b.region, "/model-invocation-job/"+url.PathEscape(jobID), &job); err != nil {
+			return nil, fmt.Errorf("unable to get batch inference job %s, %v", jobID, err)
+		}
+		return &job, nil
+	})
+}
+
+func (b *BedrockClient) GetInferenceProfile(profileArn string) (*InferenceProfile, error) {
+	return b.profiles.get(profileArn, func() (*InferenceProfile, error) {
+		region, err := arnRegion(profileArn)
+		if err != nil {
+			return nil, err
+		}
+
+		var response struct {
+			InferenceProfileArn string `json:"inferenceProfileArn"`
+			InferenceProfileID  string `json:"inferenceProfileId"`
+			Models              []struct {
+				ModelArn string `json:"modelArn"`
+			} `json:"models"`
+		}
+		if err := b.get(region, "/inference-profiles/"+url.PathEscape(profileArn), &response); err != nil {
+			return nil, fmt.Errorf("unable to get inference profile %s, %v", profileArn, err)
+		}
+
+		var modelArns []string
+		for _, model := range response.Models {
+			modelArns = append(modelArns, model.ModelArn)
+		}
+
+		profile := &InferenceProfile{ID: response.InferenceProfileID, Arn: response.InferenceProfileArn, Geography: modelsGeography(modelArns)}
+		if len(modelArns) > 0 {
+			profile.ModelID = baseModelID(modelArns[0])
+		}
+		return profile, nil
+	})
+}
+
+// get sends a signed GET request to the Bedrock API of region and decodes the JSON
+// response into v
+func (b *BedrockClient) get(region, path string, v any) error {
+	req, err := http.NewRequest(http.MethodGet, fmt.Sprintf("https://bedrock.%s.amazonaws.com%s", region, path), nil)
+	if err != nil {
+		return err
+	}
+	if _, err := v4.NewSigner(b.credentials).Sign(req, nil, "bedrock", region, time.Now()); err != nil {
+		return fmt.Errorf("unable to sign request, %v", err)
+	}
+
+	resp, err := b.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s: %s", resp.Status, body)
+	}
+
+	return json.Unmarshal(body, v)
+}
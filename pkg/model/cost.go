@@ -2,16 +2,77 @@ package model
 
 import (
 	"encoding/json"
+	"fmt"
+	"time"
 )
 
 type CostDetail struct {
 	Name     string `json:"name"`
 	Provider string `json:"provider"`
-	Cost     []struct {
-		Region                string  `json:"region"`
-		InputCostPer1KTokens  float64 `json:"input_cost_per_1k_tokens"`
-		OutputCostPer1KTokens float64 `json:"output_cost_per_1k_tokens"`
-	} `json:"cost"`
+	Cost     []Cost `json:"cost"`
+}
+
+// Cost is a price of a model in a region. EffectiveFrom is inclusive and EffectiveTo
+// exclusive; when unset the price applies without a lower or upper bound. Models that
+// are not priced by tokens, such as image models, have unit prices instead. PricingMode
+// is on_demand when unset, or batch for the discounted batch inference price.
+type Cost struct {
+	Region                string     `json:"region"`
+	PricingMode           string     `json:"pricing_mode,omitempty"`
+	EffectiveFrom         *PriceDate `json:"effective_from,omitempty"`
+	EffectiveTo           *PriceDate `json:"effective_to,omitempty"`
+	InputCostPer1KTokens  float64    `json:"input_cost_per_1k_tokens"`
+	OutputCostPer1KTokens float64    `json:"output_cost_per_1k_tokens"`
+	// Prompt cache reads and writes are billed at the input token price when unset
+	CacheReadCostPer1KTokens  float64     `json:"cache_read_cost_per_1k_tokens,omitempty"`
+	CacheWriteCostPer1KTokens float64     `json:"cache_write_cost_per_1k_tokens,omitempty"`
+	UnitPrices                []UnitPrice `json:"unit_prices,omitempty"`
+	// ProvisionedThroughput prices model units of the model by commitment term
+	ProvisionedThroughput []ProvisionedThroughputPrice `json:"provisioned_throughput,omitempty"`
+}
+
+// PriceDate is a date (2006-01-02, UTC) or RFC3339 time in models.json
+type PriceDate struct {
+	time.Time
+}
+
+func (d *PriceDate) UnmarshalJSON(data []byte) error {
+	var value string
+	if err := json.Unmarshal(data, &value); err != nil {
+		return err
+	}
+
+	if t, err := time.Parse("2006-01-02", value); err == nil {
+		d.Time = t
+		return nil
+	}
+
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return fmt.Errorf("%q is not a 2006-01-02 date or an RFC3339 time", value)
+	}
+	d.Time = t
+	return nil
+}
+
+// effectiveAt reports whether the price applies at t
+func (c *Cost) effectiveAt(t time.Time) bool {
+	if c.EffectiveFrom != nil && t.Before(c.EffectiveFrom.Time) {
+		return false
+	}
+	if c.EffectiveTo != nil && !t.Before(c.EffectiveTo.Time) {
+		return false
+	}
+	return true
+}
+
+// overlaps reports whether both prices apply at some point in time
+func (c *Cost) overlaps(other *Cost) bool {
+	// Each price starts before the other ends
+	startsBefore := func(a, b *Cost) bool {
+		return a.EffectiveFrom == nil || b.EffectiveTo == nil || a.EffectiveFrom.Before(b.EffectiveTo.Time)
+	}
+	return startsBefore(c, other) && startsBefore(other, c)
 }
 
 type CostEstimator struct {
@@ -25,20 +86,135 @@ func NewCostEstimator(modelsPriceDetails []byte) (*CostEstimator, error) {
 		return nil, err
 	}
 
+	for modelID, modelCostDetail := range modelCostDetails {
+		if err := modelCostDetail.validate(); err != nil {
+			return nil, fmt.Errorf("invalid prices for model %s, %v", modelID, err)
+		}
+	}
+
 	return &CostEstimator{modelCostDetails: modelCostDetails}, nil
 }
 
-func (m *CostEstimator) EstimateModelInvocationCost(metadata *InvocationLogMetadata) *InvocationLogMetadata {
+// validate checks that prices in the same region have valid, non-overlapping ranges,
+// so at most one price applies to an invocation. Prices in "any" region may overlap
+// prices in a specific region, which take precedence.
+func (d *CostDetail) validate() error {
+	for i := range d.Cost {
+		cost := &d.Cost[i]
+		if cost.EffectiveFrom != nil && cost.EffectiveTo != nil && !cost.EffectiveFrom.Before(cost.EffectiveTo.Time) {
+			return fmt.Errorf("price in region %s is effective from %s, after it ends on %s", cost.Region, cost.EffectiveFrom.Format(time.RFC3339), cost.EffectiveTo.Format(time.RFC3339))
+		}
+
+		if cost.PricingMode != "" && cost.PricingMode != PricingModeOnDemand && cost.PricingMode != PricingModeBatch {
+			return fmt.Errorf("unsupported pricing mode %q in region %s", cost.PricingMode, cost.Region)
+		}
+
+		for _, unitPrice := range cost.UnitPrices {
+			switch unitPrice.Unit {
+			case PricingUnitImage, PricingUnitSecond, PricingUnitRequest:
+			default:
+				return fmt.Errorf("unsupported pricing unit %q in region %s", unitPrice.Unit, cost.Region)
+			}
+		}
+
+		for _, price := range cost.ProvisionedThroughput {
+			if !validCommitment(price.Commitment) {
+				return fmt.Errorf("unsupported provisioned throughput commitment %q in region %s", price.Commitment, cost.Region)
+			}
+		}
+
+		for j := i + 1; j < len(d.Cost); j++ {
+			if other := &d.Cost[j]; other.Region == cost.Region && other.pricingMode() == cost.pricingMode() && cost.overlaps(other) {
+				return fmt.Errorf("%s prices in region %s have overlapping effective dates", cost.pricingMode(), cost.Region)
+			}
+		}
+	}
+	return nil
+}
+
+func (c *Cost) pricingMode() string {
+	if c.PricingMode == "" {
+		return PricingModeOnDemand
+	}
+	return c.PricingMode
+}
+
+// cost returns the model's price effective at the invocation's timestamp in the
+// invocation's region or, when there is none, in "any" region. Batch inference uses
+// the batch price when the model has one, and the on-demand price otherwise.
+func (m *CostEstimator) cost(metadata *InvocationLogMetadata) (*CostDetail, *Cost) {
 	modelCostDetail, ok := m.modelCostDetails[metadata.ModelID]
-	if ok {
-		for _, costByRegion := range modelCostDetail.Cost {
-			if costByRegion.Region == "any" || costByRegion.Region == metadata.Region {
-				metadata.InputTokenCostUSD = (costByRegion.InputCostPer1KTokens / 1000) * float64(metadata.InputTokenCount)
-				metadata.OutputTokenCostUSD = (costByRegion.OutputCostPer1KTokens / 1000) * float64(metadata.OutputTokenCount)
-				metadata.ModelProvider = modelCostDetail.Provider
-				metadata.ModelName = modelCostDetail.Name
-				break
+	if !ok {
+		return nil, nil
+	}
+
+	pricingModes := []string{PricingModeOnDemand}
+	if metadata.PricingMode == PricingModeBatch {
+		pricingModes = []string{PricingModeBatch, PricingModeOnDemand}
+	}
+
+	for _, pricingMode := range pricingModes {
+		for _, region := range []string{metadata.Region, "any"} {
+			for i := range modelCostDetail.Cost {
+				costByRegion := &modelCostDetail.Cost[i]
+				if costByRegion.pricingMode() == pricingMode && costByRegion.Region == region && costByRegion.effectiveAt(metadata.Timestamp) {
+					return modelCostDetail, costByRegion
+				}
+			}
+		}
+	}
+	return nil, nil
+}
+
+func (m *CostEstimator) EstimateModelInvocationCost(metadata *InvocationLogMetadata) *InvocationLogMetadata {
+	modelCostDetail, costByRegion := m.cost(metadata)
+	if costByRegion != nil {
+		metadata.ModelProvider = modelCostDetail.Provider
+		metadata.ModelName = modelCostDetail.Name
+
+		// Provisioned throughput is billed by the hour, not by token
+		if metadata.PricingMode == PricingModeProvisioned {
+			return metadata
+		}
+
+		metadata.InputTokenCostUSD = (costByRegion.InputCostPer1KTokens / 1000) * float64(metadata.InputTokenCount)
+		metadata.OutputTokenCostUSD = (costByRegion.OutputCostPer1KTokens / 1000) * float64(metadata.OutputTokenCount)
+
+		cacheReadCostPer1KTokens := costByRegion.InputCostPer1KTokens
+		if costByRegion.CacheReadCostPer1KTokens != 0 {
+			cacheReadCostPer1KTokens = costByRegion.CacheReadCostPer1KTokens
+		}
+		cacheWriteCostPer1KTokens := costByRegion.InputCostPer1KTokens
+		if costByRegion.CacheWriteCostPer1KTokens != 0 {
+			cacheWriteCostPer1KTokens = costByRegion.CacheWriteCostPer1KTokens
+		}
+
+		metadata.CacheReadInputTokenCostUSD = (cacheReadCostPer1KTokens / 1000) * float64(metadata.CacheReadInputTokenCount)
+		metadata.CacheWriteInputTokenCostUSD = (cacheWriteCostPer1KTokens / 1000) * float64(metadata.CacheWriteInputTokenCount)
+		cacheTokenCount := metadata.CacheReadInputTokenCount + metadata.CacheWriteInputTokenCount
+		metadata.CacheSavingsUSD = (costByRegion.InputCostPer1KTokens/1000)*float64(cacheTokenCount) - metadata.CacheReadInputTokenCostUSD - metadata.CacheWriteInputTokenCostUSD
+	}
+
+	return metadata
+}
+
+// EstimateModelInvocationUnitCost prices the invocation's images, seconds or request
+// when its model has unit prices
+func (m *CostEstimator) EstimateModelInvocationUnitCost(metadata *InvocationLogMetadata, usage UnitUsage) *InvocationLogMetadata {
+	_, costByRegion := m.cost(metadata)
+	if costByRegion == nil {
+		return metadata
+	}
+
+	for _, unitPrice := range costByRegion.UnitPrices {
+		if unitPrice.matches(usage) {
+			metadata.PricingUnit = unitPrice.Unit
+			metadata.UnitCount = usage.Count(unitPrice.Unit)
+			// Provisioned throughput is billed by the hour, not by unit
+			if metadata.PricingMode != PricingModeProvisioned {
+				metadata.UnitCostUSD = unitPrice.Price * metadata.UnitCount
 			}
+			break
 		}
 	}
 
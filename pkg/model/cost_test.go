@@ -4,8 +4,10 @@ import (
 	"fmt"
 	"io"
 	"log"
+	"math"
 	"os"
 	"testing"
+	"time"
 )
 
 func TestCostEstimator_EstimateModelInvocationCostAnyRegion(t *testing.T) {
@@ -91,3 +93,145 @@ func TestCostEstimator_EstimateModelInvocationCostSpecificRegion(t *testing.T) {
 		t.Errorf("got %f, wanted %f", metadata.OutputTokenCostUSD, 0.0016)
 	}
 }
+
+const effectiveDatedPrices = `{
+  "anthropic.claude-v2": {
+    "name": "Claude",
+    "provider": "Anthropic",
+    "cost": [
+      {
+        "region": "any",
+        "effective_from": "2024-01-01",
+        "input_cost_per_1k_tokens": 0.02,
+        "output_cost_per_1k_tokens": 0.06
+      },
+      {
+        "region": "us-east-1",
+        "effective_to": "2024-03-01",
+        "input_cost_per_1k_tokens": 0.008,
+        "output_cost_per_1k_tokens": 0.024
+      },
+      {
+        "region": "us-east-1",
+        "effective_from": "2024-03-01",
+        "input_cost_per_1k_tokens": 0.004,
+        "output_cost_per_1k_tokens": 0.012
+      },
+      {
+        "region": "any",
+        "effective_to": "2024-01-01",
+        "input_cost_per_1k_tokens": 0.01,
+        "output_cost_per_1k_tokens": 0.03
+      }
+    ]
+  }
+}`
+
+func TestCostEstimator_EstimateModelInvocationCostEffectiveDate(t *testing.T) {
+	costEstimator, err := NewCostEstimator([]byte(effectiveDatedPrices))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Prices in us-east-1 take precedence over the overlapping prices in any region
+	tests := []struct {
+		timestamp time.Time
+		region    string
+		wanted    float64
+	}{
+		{timestamp: time.Date(2024, 2, 29, 23, 59, 59, 0, time.UTC), region: "us-east-1", wanted: 0.008},
+		{timestamp: time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC), region: "us-east-1", wanted: 0.004},
+		{timestamp: time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC), region: "us-east-1", wanted: 0.004},
+		{timestamp: time.Date(2023, 12, 31, 0, 0, 0, 0, time.UTC), region: "us-west-2", wanted: 0.01},
+		{timestamp: time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC), region: "us-west-2", wanted: 0.02},
+	}
+
+	for _, test := range tests {
+		metadata := costEstimator.EstimateModelInvocationCost(&InvocationLogMetadata{
+			Timestamp:       test.timestamp,
+			Region:          test.region,
+			ModelID:         "anthropic.claude-v2",
+			InputTokenCount: 1000,
+		})
+		if metadata.InputTokenCostUSD != test.wanted {
+			t.Errorf("%s %s: got %f, wanted %f", test.region, test.timestamp, metadata.InputTokenCostUSD, test.wanted)
+		}
+	}
+}
+
+func TestNewCostEstimatorInvalidEffectiveDates(t *testing.T) {
+	tests := map[string]string{
+		"overlapping": `{"m": {"cost": [
+			{"region": "us-east-1", "effective_to": "2024-03-02"},
+			{"region": "us-east-1", "effective_from": "2024-03-01"}
+		]}}`,
+		"unbounded": `{"m": {"cost": [
+			{"region": "us-east-1"},
+			{"region": "us-east-1", "effective_from": "2024-03-01T00:00:00Z"}
+		]}}`,
+		"reversed": `{"m": {"cost": [
+			{"region": "us-east-1", "effective_from": "2024-03-01", "effective_to": "2024-02-01"}
+		]}}`,
+		"malformed": `{"m": {"cost": [
+			{"region": "us-east-1", "effective_from": "March 2024"}
+		]}}`,
+	}
+
+	for name, prices := range tests {
+		if _, err := NewCostEstimator([]byte(prices)); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+
+	adjacent := `{"m": {"cost": [
+		{"region": "us-east-1", "effective_to": "2024-03-01"},
+		{"region": "us-east-1", "effective_from": "2024-03-01"},
+		{"region": "us-west-2"}
+	]}}`
+	if _, err := NewCostEstimator([]byte(adjacent)); err != nil {
+		t.Errorf("got %v, wanted adjacent ranges to be valid", err)
+	}
+}
+
+func TestCostEstimator_EstimateModelInvocationCostPromptCache(t *testing.T) {
+	costEstimator, err := NewCostEstimator([]byte(`{
+	  "cached": {"cost": [{"region": "us-east-1", "input_cost_per_1k_tokens": 0.003, "output_cost_per_1k_tokens": 0.015, "cache_write_cost_per_1k_tokens": 0.00375, "cache_read_cost_per_1k_tokens": 0.0003}]},
+	  "uncached": {"cost": [{"region": "us-east-1", "input_cost_per_1k_tokens": 0.003, "output_cost_per_1k_tokens": 0.015}]}
+	}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		modelID          string
+		wantedReadCost   float64
+		wantedWriteCost  float64
+		wantedSavingsUSD float64
+	}{
+		{modelID: "cached", wantedReadCost: 0.003, wantedWriteCost: 0.00375, wantedSavingsUSD: 0.027 - 0.00075},
+		{modelID: "uncached", wantedReadCost: 0.03, wantedWriteCost: 0.003, wantedSavingsUSD: 0},
+	}
+
+	for _, test := range tests {
+		metadata := costEstimator.EstimateModelInvocationCost(&InvocationLogMetadata{
+			Region:                    "us-east-1",
+			ModelID:                   test.modelID,
+			InputTokenCount:           100,
+			CacheReadInputTokenCount:  10000,
+			CacheWriteInputTokenCount: 1000,
+		})
+
+		if math.Abs(metadata.CacheReadInputTokenCostUSD-test.wantedReadCost) > 1e-12 {
+			t.Errorf("%s: got %f, wanted %f", test.modelID, metadata.CacheReadInputTokenCostUSD, test.wantedReadCost)
+		}
+		if math.Abs(metadata.CacheWriteInputTokenCostUSD-test.wantedWriteCost) > 1e-12 {
+			t.Errorf("%s: got %f, wanted %f", test.modelID, metadata.CacheWriteInputTokenCostUSD, test.wantedWriteCost)
+		}
+		if math.Abs(metadata.CacheSavingsUSD-test.wantedSavingsUSD) > 1e-12 {
+			t.Errorf("%s: got %f, wanted %f", test.modelID, metadata.CacheSavingsUSD, test.wantedSavingsUSD)
+		}
+		if math.Abs(metadata.InputTokenCostUSD-0.0003) > 1e-12 {
+			t.Errorf("%s: got %f, wanted %f", test.modelID, metadata.InputTokenCostUSD, 0.0003)
+		}
+	}
+}
@@ -0,0 +1,150 @@
+package model
+
+import (
+	"sort"
+	"time"
+)
+
+// DuplicatePromptRollup reports how many of an hour's invocations repeated a prompt
+// already sent to the same model in that hour, and what the repeats cost. A repeat has
+// the same prompt and system prompt fingerprints as an earlier invocation.
+type DuplicatePromptRollup struct {
+	Hour                 time.Time              `json:"hour"`
+	AccountID            string                 `json:"accountId"`
+	Region               string                 `json:"region"`
+	Invocations          int                    `json:"invocations"`
+	DuplicateInvocations int                    `json:"duplicateInvocations"`
+	DuplicateRate        float64                `json:"duplicateRate"`
+	CostUSD              float64                `json:"costUSD"`
+	DuplicateCostUSD     float64                `json:"duplicateCostUSD"`
+	Groups               []DuplicatePromptGroup `json:"groups"`
+}
+
+// DuplicatePromptGroup is the share of repeats among the invocations of a model with
+// the same identity tags
+type DuplicatePromptGroup struct {
+	ModelID              string        `json:"modelId"`
+	IdentityTags         []IdentityTag `json:"identityTags"`
+	Invocations          int           `json:"invocations"`
+	DuplicateInvocations int           `json:"duplicateInvocations"`
+	DuplicateRate        float64       `json:"duplicateRate"`
+	CostUSD              float64       `json:"costUSD"`
+	DuplicateCostUSD     float64       `json:"duplicateCostUSD"`
+}
+
+// RollupDuplicatePrompts finds the repeated prompts among the given invocations of an
+// hour. Invocations without a prompt fingerprint are left out, and nil is returned
+// when there are none.
+func (m *MetadataGenerator) RollupDuplicatePrompts(accountID, region string, hour time.Time, metadata []InvocationLogMetadata) *DuplicatePromptRollup {
+	aggregator := NewDuplicatePromptAggregator(accountID, region, hour)
+	for i := range metadata {
+		aggregator.Add(&metadata[i])
+	}
+	return aggregator.Rollup()
+}
+
+type promptKey struct{ modelID, systemPrompt, prompt string }
+
+// firstPrompt is the earliest invocation with a prompt added so far
+type firstPrompt struct {
+	timestamp time.Time
+	groupKey  string
+	costUSD   float64
+}
+
+// DuplicatePromptAggregator finds the repeated prompts of an hour as invocations are
+// added, in any order, keeping only the earliest invocation of each prompt rather
+// than every invocation of the hour. An invocation repeats a prompt when an
+// invocation with the same prompt was sent earlier, or at the same time but added
+// first.
+type DuplicatePromptAggregator struct {
+	rollup *DuplicatePromptRollup
+	groups map[string]*DuplicatePromptGroup
+	first  map[promptKey]firstPrompt
+}
+
+// NewDuplicatePromptAggregator creates an aggregator for the invocations of an hour
+// in the account and region
+func NewDuplicatePromptAggregator(accountID, region string, hour time.Time) *DuplicatePromptAggregator {
+	return &DuplicatePromptAggregator{
+		rollup: &DuplicatePromptRollup{
+			Hour:      hour,
+			AccountID: accountID,
+			Region:    region,
+			Groups:    []DuplicatePromptGroup{},
+		},
+		groups: make(map[string]*DuplicatePromptGroup),
+		first:  make(map[promptKey]firstPrompt),
+	}
+}
+
+// Add counts the invocation when it has a prompt fingerprint
+func (a *DuplicatePromptAggregator) Add(invocation *InvocationLogMetadata) {
+	if invocation.PromptFingerprint == "" {
+		return
+	}
+
+	groupKey := invocation.ModelID + "\x00" + identityTagsKey(invocation.IdentityTags)
+	group, ok := a.groups[groupKey]
+	if !ok {
+		group = &DuplicatePromptGroup{ModelID: invocation.ModelID, IdentityTags: invocation.IdentityTags}
+		a.groups[groupKey] = group
+	}
+
+	cost := invocation.TotalCostUSD()
+	group.Invocations++
+	group.CostUSD += cost
+	a.rollup.Invocations++
+	a.rollup.CostUSD += cost
+
+	prompt := promptKey{invocation.ModelID, invocation.SystemPromptFingerprint, invocation.PromptFingerprint}
+	current := firstPrompt{timestamp: invocation.Timestamp, groupKey: groupKey, costUSD: cost}
+	first, seen := a.first[prompt]
+	switch {
+	case !seen:
+		a.first[prompt] = current
+	case current.timestamp.Before(first.timestamp):
+		// The invocation first seen as the original turns out to be a repeat
+		a.first[prompt] = current
+		a.addDuplicate(first)
+	default:
+		a.addDuplicate(current)
+	}
+}
+
+func (a *DuplicatePromptAggregator) addDuplicate(duplicate firstPrompt) {
+	group := a.groups[duplicate.groupKey]
+	group.DuplicateInvocations++
+	group.DuplicateCostUSD += duplicate.costUSD
+	a.rollup.DuplicateInvocations++
+	a.rollup.DuplicateCostUSD += duplicate.costUSD
+}
+
+// Rollup returns the rollup of the added invocations, or nil when none had a prompt
+// fingerprint
+func (a *DuplicatePromptAggregator) Rollup() *DuplicatePromptRollup {
+	if a.rollup.Invocations == 0 {
+		return nil
+	}
+
+	rollup := *a.rollup
+	rollup.DuplicateRate = float64(rollup.DuplicateInvocations) / float64(rollup.Invocations)
+
+	keys := make([]string, 0, len(a.groups))
+	for key := range a.groups {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	rollup.Groups = make([]DuplicatePromptGroup, 0, len(keys))
+	for _, key := range keys {
+		group := *a.groups[key]
+		group.DuplicateRate = float64(group.DuplicateInvocations) / float64(group.Invocations)
+		rollup.Groups = append(rollup.Groups, group)
+	}
+	return &rollup
+}
+
+// FingerprintsEnabled reports whether prompts are fingerprinted
+func (m *MetadataGenerator) FingerprintsEnabled() bool {
+	return m.fingerprints != nil
+}
@@ -0,0 +1,93 @@
+package model
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func TestMetadataGenerator_RollupDuplicatePrompts(t *testing.T) {
+	modelMetaDataGenerator := NewMetadataGenerator(nil, nil, nil, GeneratorOptions{Fingerprints: NewFingerprinter("pepper", NormalizeWhitespace)})
+	hour := time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)
+	search := []IdentityTag{{Key: "team", Value: "search"}}
+
+	invocation := func(minute int, modelID, prompt string, identityTags []IdentityTag, costUSD float64) InvocationLogMetadata {
+		return InvocationLogMetadata{
+			Timestamp:         hour.Add(time.Duration(minute) * time.Minute),
+			ModelID:           modelID,
+			IdentityTags:      identityTags,
+			PromptFingerprint: prompt,
+			InputTokenCostUSD: costUSD,
+		}
+	}
+
+	metadata := []InvocationLogMetadata{
+		invocation(30, "anthropic.claude-3-haiku-20240307-v1", "a", search, 0.3),
+		invocation(10, "anthropic.claude-3-haiku-20240307-v1", "a", nil, 0.1),
+		invocation(20, "anthropic.claude-3-haiku-20240307-v1", "a", search, 0.2),
+		invocation(40, "anthropic.claude-3-haiku-20240307-v1", "b", search, 0.4),
+		// The same prompt to another model is not a repeat
+		invocation(50, "meta.llama3-8b-instruct-v1", "a", search, 0.5),
+		// Invocations without a fingerprint are left out
+		invocation(55, "meta.llama3-8b-instruct-v1", "", search, 0.6),
+	}
+
+	rollup := modelMetaDataGenerator.RollupDuplicatePrompts("123456789012", "us-east-1", hour, metadata)
+	if rollup == nil {
+		t.Fatal("got no rollup")
+	}
+
+	if rollup.Invocations != 5 || rollup.DuplicateInvocations != 2 {
+		t.Errorf("got %d invocations and %d duplicates, wanted 5 and 2", rollup.Invocations, rollup.DuplicateInvocations)
+	}
+	if math.Abs(rollup.DuplicateCostUSD-0.5) > 1e-9 || math.Abs(rollup.DuplicateRate-0.4) > 1e-9 {
+		t.Errorf("got %f duplicate cost and %f rate, wanted 0.5 and 0.4", rollup.DuplicateCostUSD, rollup.DuplicateRate)
+	}
+
+	if len(rollup.Groups) != 3 {
+		t.Fatalf("got %+v, wanted 3 groups", rollup.Groups)
+	}
+	// The first invocation of the prompt had no identity tags, so both repeats are search's
+	haiku := rollup.Groups[1]
+	if haiku.ModelID != "anthropic.claude-3-haiku-20240307-v1" || len(haiku.IdentityTags) != 1 {
+		t.Fatalf("got %+v, wanted the haiku invocations of search", haiku)
+	}
+	if haiku.Invocations != 3 || haiku.DuplicateInvocations != 2 || math.Abs(haiku.DuplicateCostUSD-0.5) > 1e-9 {
+		t.Errorf("got %+v, wanted 3 invocations with 2 duplicates costing 0.5", haiku)
+	}
+
+	// The same prompt with another system prompt is not a repeat
+	withSystemPrompt := invocation(45, "anthropic.claude-3-haiku-20240307-v1", "a", search, 0.1)
+	withSystemPrompt.SystemPromptFingerprint = "s"
+	rollup = modelMetaDataGenerator.RollupDuplicatePrompts("123456789012", "us-east-1", hour, append(metadata[:1:1], withSystemPrompt))
+	if rollup.DuplicateInvocations != 0 {
+		t.Errorf("got %d duplicates, wanted 0", rollup.DuplicateInvocations)
+	}
+
+	if got := modelMetaDataGenerator.RollupDuplicatePrompts("123456789012", "us-east-1", hour, metadata[5:]); got != nil {
+		t.Errorf("got %+v, wanted no rollup without fingerprints", got)
+	}
+}
+
+func TestDuplicatePromptAggregatorOrder(t *testing.T) {
+	hour := time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)
+	metadata := []InvocationLogMetadata{
+		{Timestamp: hour.Add(10 * time.Minute), ModelID: "anthropic.claude-3-haiku-20240307-v1", PromptFingerprint: "a", InputTokenCostUSD: 0.1},
+		{Timestamp: hour.Add(20 * time.Minute), ModelID: "anthropic.claude-3-haiku-20240307-v1", PromptFingerprint: "a", InputTokenCostUSD: 0.2},
+		{Timestamp: hour.Add(30 * time.Minute), ModelID: "anthropic.claude-3-haiku-20240307-v1", PromptFingerprint: "a", InputTokenCostUSD: 0.3},
+	}
+
+	// Objects of an hour are read in any order, so the earliest invocation of a prompt
+	// may be added last
+	for _, order := range [][]int{{0, 1, 2}, {2, 1, 0}, {1, 2, 0}} {
+		aggregator := NewDuplicatePromptAggregator("123456789012", "us-east-1", hour)
+		for _, i := range order {
+			aggregator.Add(&metadata[i])
+		}
+
+		rollup := aggregator.Rollup()
+		if rollup.DuplicateInvocations != 2 || math.Abs(rollup.DuplicateCostUSD-0.5) > 1e-9 {
+			t.Errorf("%v: got %d duplicates costing %f, wanted 2 costing 0.5", order, rollup.DuplicateInvocations, rollup.DuplicateCostUSD)
+		}
+	}
+}
@@ -0,0 +1,170 @@
+package model
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// promptPaths are the paths of the prompt in the request bodies of Converse and of the
+// model providers, from messages APIs to text completion APIs
+var promptPaths = []string{"messages", "prompt", "inputText", "chat_history", "message"}
+
+// systemPromptPaths are the paths of the system prompt, given apart from the messages
+var systemPromptPaths = []string{"system"}
+
+// completionPaths are the paths of the generated text in response bodies, or in the
+// chunks of response streams
+var completionPaths = []string{
+	"output.message.content",
+	"content",
+	"completion",
+	"results",
+	"outputText",
+	"generation",
+	"outputs",
+	"choices",
+	"generations",
+	"completions",
+	"text",
+	"contentBlockDelta.delta.text",
+	"delta.text",
+}
+
+// Normalization is how text is normalized before it is fingerprinted
+type Normalization string
+
+const (
+	// NormalizeWhitespace collapses runs of whitespace into a single space
+	NormalizeWhitespace Normalization = "whitespace"
+	// NormalizeCase also folds the text to lower case
+	NormalizeCase Normalization = "case"
+	// NormalizeNone fingerprints the text as it was sent
+	NormalizeNone Normalization = "none"
+)
+
+// ParseNormalization parses a configured text normalization. An empty value selects
+// NormalizeWhitespace.
+func ParseNormalization(value string) (Normalization, error) {
+	switch Normalization(strings.ToLower(strings.TrimSpace(value))) {
+	case "", NormalizeWhitespace:
+		return NormalizeWhitespace, nil
+	case NormalizeCase:
+		return NormalizeCase, nil
+	case NormalizeNone:
+		return NormalizeNone, nil
+	default:
+		return "", fmt.Errorf("unsupported fingerprint normalization %q", value)
+	}
+}
+
+// Fingerprinter computes keyed fingerprints of prompts, system prompts and
+// completions, so identical requests can be found without retaining their text. Text
+// is normalized before it is fingerprinted with HMAC-SHA256; content blocks without
+// text, such as images and tool use, contribute a digest of their content instead.
+type Fingerprinter struct {
+	key           []byte
+	normalization Normalization
+}
+
+// NewFingerprinter creates a fingerprinter with the given HMAC key and normalization
+func NewFingerprinter(key string, normalization Normalization) *Fingerprinter {
+	return &Fingerprinter{key: []byte(key), normalization: normalization}
+}
+
+// apply sets the fingerprints of the prompt, system prompt and completion of the
+// invocation log
+func (f *Fingerprinter) apply(invocationLog *InvocationLog, metadata *InvocationLogMetadata) {
+	inputBody, outputBody := invocationLog.Input.InputBodyJSON, invocationLog.Output.OutputBodyJSON
+
+	metadata.PromptFingerprint = f.fingerprint(bodyText(inputBody, promptPaths))
+	metadata.SystemPromptFingerprint = f.fingerprint(bodyText(inputBody, systemPromptPaths))
+
+	// Stream output bodies are arrays of chunks, whose text is concatenated
+	if chunks, ok := outputBody.([]any); ok {
+		var completion strings.Builder
+		for _, chunk := range chunks {
+			completion.WriteString(bodyText(chunk, completionPaths))
+		}
+		metadata.CompletionFingerprint = f.fingerprint(completion.String())
+	} else {
+		metadata.CompletionFingerprint = f.fingerprint(bodyText(outputBody, completionPaths))
+	}
+}
+
+// fingerprint returns the hex HMAC of the normalized text, or nothing for no text
+func (f *Fingerprinter) fingerprint(text string) string {
+	if f.normalization != NormalizeNone {
+		text = strings.Join(strings.Fields(text), " ")
+	}
+	if f.normalization == NormalizeCase {
+		text = strings.ToLower(text)
+	}
+	if strings.TrimSpace(text) == "" {
+		return ""
+	}
+
+	mac := hmac.New(sha256.New, f.key)
+	mac.Write([]byte(text))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// bodyText returns the text at paths in body, such as the history and the message
+// of a chat request
+func bodyText(body any, paths []string) string {
+	var texts []string
+	for _, path := range paths {
+		if text := valueText(jsonValue(body, path)); text != "" {
+			texts = append(texts, text)
+		}
+	}
+	return strings.Join(texts, "\n")
+}
+
+// valueText returns the text of a string, of the elements of an array, or of the text
+// fields of an object. The role of a message is kept, so the same text in different
+// turns differs. An object without text fields, such as an image or tool use block,
+// is replaced by a digest of its JSON encoding.
+func valueText(value any) string {
+	switch value := value.(type) {
+	case string:
+		return value
+	case []any:
+		texts := make([]string, 0, len(value))
+		for _, element := range value {
+			if text := valueText(element); text != "" {
+				texts = append(texts, text)
+			}
+		}
+		return strings.Join(texts, "\n")
+	case map[string]any:
+		for _, key := range []string{"text", "outputText", "content", "message", "data", "generation"} {
+			if field, ok := value[key]; ok {
+				text := valueText(field)
+				if role, ok := value["role"].(string); ok && text != "" {
+					return role + ": " + text
+				}
+				return text
+			}
+		}
+		return blockDigest(value)
+	}
+	return ""
+}
+
+// blockDigest returns a placeholder for a content block that identifies its content.
+// Object keys are encoded in sorted order, so equal blocks have equal digests.
+func blockDigest(block map[string]any) string {
+	if len(block) == 0 {
+		return ""
+	}
+	encoded, err := json.Marshal(block)
+	if err != nil {
+		return ""
+	}
+	digest := sha256.Sum256(encoded)
+	return "[block " + hex.EncodeToString(digest[:]) + "]"
+}
@@ -0,0 +1,168 @@
+package model
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func TestFingerprinter_Apply(t *testing.T) {
+	fingerprinter := NewFingerprinter("pepper", NormalizeWhitespace)
+
+	converse := &InvocationLogMetadata{}
+	fingerprinter.apply(readInvocationLog(t, "test_data/input_converse.json"), converse)
+	converseStream := &InvocationLogMetadata{}
+	fingerprinter.apply(readInvocationLog(t, "test_data/input_converse_stream.json"), converseStream)
+
+	if converse.PromptFingerprint == "" || converse.CompletionFingerprint == "" {
+		t.Fatalf("got %+v, wanted prompt and completion fingerprints", converse)
+	}
+	if converse.SystemPromptFingerprint != "" {
+		t.Errorf("got %q, wanted no system prompt fingerprint", converse.SystemPromptFingerprint)
+	}
+
+	// A streamed completion has the fingerprint of the same completion returned at once
+	if converseStream.PromptFingerprint != converse.PromptFingerprint {
+		t.Errorf("got %q, wanted %q", converseStream.PromptFingerprint, converse.PromptFingerprint)
+	}
+	if converseStream.CompletionFingerprint != converse.CompletionFingerprint {
+		t.Errorf("got %q, wanted %q", converseStream.CompletionFingerprint, converse.CompletionFingerprint)
+	}
+
+	otherKey := &InvocationLogMetadata{}
+	NewFingerprinter("salt", NormalizeWhitespace).apply(readInvocationLog(t, "test_data/input_converse.json"), otherKey)
+	if otherKey.PromptFingerprint == converse.PromptFingerprint {
+		t.Errorf("got the same fingerprint %q with another key", otherKey.PromptFingerprint)
+	}
+}
+
+func TestFingerprinter_ApplyInvokeModel(t *testing.T) {
+	fingerprinter := NewFingerprinter("pepper", NormalizeWhitespace)
+
+	tests := []struct {
+		inputBody  string
+		outputBody string
+		prompt     string
+		system     string
+		completion string
+	}{
+		{
+			inputBody:  `{"system": "Be brief.", "messages": [{"role": "user", "content": [{"type": "text", "text": "Hello  there"}]}], "max_tokens": 10}`,
+			outputBody: `{"content": [{"type": "text", "text": "Hi."}], "stop_reason": "end_turn"}`,
+			prompt:     "user: Hello there",
+			system:     "Be brief.",
+			completion: "Hi.",
+		},
+		{
+			inputBody:  `{"prompt": "\n\nHuman: Hello\n\nAssistant:", "max_tokens_to_sample": 10}`,
+			outputBody: `{"completion": " Hi.", "stop_reason": "stop_sequence"}`,
+			prompt:     "Human: Hello Assistant:",
+			completion: "Hi.",
+		},
+		{
+			inputBody:  `{"message": "And now?", "chat_history": [{"role": "USER", "message": "Hello"}]}`,
+			outputBody: `{"text": "Hi.", "finish_reason": "COMPLETE"}`,
+			prompt:     "USER: Hello\nAnd now?",
+			completion: "Hi.",
+		},
+		{
+			inputBody:  `{"system": [{"text": "Be brief."}], "messages": [{"role": "user", "content": [{"text": "Hello"}]}]}`,
+			outputBody: `{"output": {"message": {"role": "assistant", "content": [{"text": "Hi."}]}}, "stopReason": "end_turn"}`,
+			prompt:     "user: Hello",
+			system:     "Be brief.",
+			completion: "Hi.",
+		},
+		{
+			inputBody:  `{"inputText": "Hello", "textGenerationConfig": {"maxTokenCount": 10}}`,
+			outputBody: `{"inputTextTokenCount": 1, "results": [{"outputText": "Hi.", "completionReason": "FINISH"}]}`,
+			prompt:     "Hello",
+			completion: "Hi.",
+		},
+	}
+
+	for _, test := range tests {
+		invocationLog := &InvocationLog{}
+		if err := json.Unmarshal([]byte(test.inputBody), &invocationLog.Input.InputBodyJSON); err != nil {
+			t.Fatal(err)
+		}
+		if err := json.Unmarshal([]byte(test.outputBody), &invocationLog.Output.OutputBodyJSON); err != nil {
+			t.Fatal(err)
+		}
+
+		got := &InvocationLogMetadata{}
+		fingerprinter.apply(invocationLog, got)
+
+		wanted := &InvocationLogMetadata{
+			PromptFingerprint:       fingerprinter.fingerprint(test.prompt),
+			SystemPromptFingerprint: fingerprinter.fingerprint(test.system),
+			CompletionFingerprint:   fingerprinter.fingerprint(test.completion),
+		}
+		if !reflect.DeepEqual(got, wanted) {
+			t.Errorf("%s: got %+v, wanted %+v", test.inputBody, got, wanted)
+		}
+	}
+}
+
+func TestFingerprinter_ApplyNonTextBlocks(t *testing.T) {
+	fingerprinter := NewFingerprinter("pepper", NormalizeWhitespace)
+
+	prompt := func(inputBody string) string {
+		invocationLog := &InvocationLog{}
+		if err := json.Unmarshal([]byte(inputBody), &invocationLog.Input.InputBodyJSON); err != nil {
+			t.Fatal(err)
+		}
+		metadata := &InvocationLogMetadata{}
+		fingerprinter.apply(invocationLog, metadata)
+		return metadata.PromptFingerprint
+	}
+
+	cat := prompt(`{"messages": [{"role": "user", "content": [{"image": {"format": "png", "source": {"bytes": "Y2F0"}}}, {"text": "What is this?"}]}]}`)
+	sameCat := prompt(`{"messages": [{"role": "user", "content": [{"image": {"source": {"bytes": "Y2F0"}, "format": "png"}}, {"text": "What  is this?"}]}]}`)
+	dog := prompt(`{"messages": [{"role": "user", "content": [{"image": {"format": "png", "source": {"bytes": "ZG9n"}}}, {"text": "What is this?"}]}]}`)
+	if cat == "" || cat != sameCat {
+		t.Errorf("got %q and %q, wanted the same fingerprint for the same image", cat, sameCat)
+	}
+	if cat == dog {
+		t.Errorf("got the same fingerprint %q for different images", cat)
+	}
+
+	toolUse := prompt(`{"messages": [{"role": "assistant", "content": [{"type": "tool_use", "id": "a", "name": "weather", "input": {"city": "Paris"}}]}]}`)
+	otherToolUse := prompt(`{"messages": [{"role": "assistant", "content": [{"type": "tool_use", "id": "a", "name": "weather", "input": {"city": "Rome"}}]}]}`)
+	if toolUse == "" || toolUse == otherToolUse {
+		t.Errorf("got %q and %q, wanted different fingerprints for different tool inputs", toolUse, otherToolUse)
+	}
+}
+
+func TestFingerprinter_Normalization(t *testing.T) {
+	tests := []struct {
+		normalization Normalization
+		same          bool
+	}{
+		{normalization: NormalizeNone, same: false},
+		{normalization: NormalizeWhitespace, same: false},
+		{normalization: NormalizeCase, same: true},
+	}
+
+	for _, test := range tests {
+		fingerprinter := NewFingerprinter("pepper", test.normalization)
+		if same := fingerprinter.fingerprint("Hello  there") == fingerprinter.fingerprint("hello there"); same != test.same {
+			t.Errorf("%s: got same %t, wanted %t", test.normalization, same, test.same)
+		}
+	}
+
+	whitespace := NewFingerprinter("pepper", NormalizeWhitespace)
+	if whitespace.fingerprint("Hello  there\n") != whitespace.fingerprint("Hello there") {
+		t.Errorf("got different fingerprints, wanted whitespace to be collapsed")
+	}
+	none := NewFingerprinter("pepper", NormalizeNone)
+	if none.fingerprint("Hello  there") == none.fingerprint("Hello there") {
+		t.Errorf("got the same fingerprint, wanted whitespace to be kept")
+	}
+
+	if _, err := ParseNormalization("stemming"); err == nil {
+		t.Errorf("expected an error for an unsupported normalization")
+	}
+	if normalization, err := ParseNormalization(""); err != nil || normalization != NormalizeWhitespace {
+		t.Errorf("got %q, wanted %q", normalization, NormalizeWhitespace)
+	}
+}
@@ -7,10 +7,14 @@ import (
 	"github.com/aws/aws-sdk-go/aws/arn"
 	"github.com/aws/aws-sdk-go/service/iam"
 	"strings"
+	"sync"
 )
 
+// IdentityTagsBuilder looks up and caches the tags of IAM identities. It is used by the
+// object and hour workers at once, so the cache is guarded by mu.
 type IdentityTagsBuilder struct {
 	iamClient       *iam.IAM
+	mu              sync.Mutex
 	entityTagsCache map[string][]*iam.Tag
 }
 
@@ -39,7 +43,9 @@ func (i *IdentityTagsBuilder) GetIdentityTags(identity string) (tags []*iam.Tag,
 
 	iamEntityType, entityName := i.parseIamEntity(identity)
 
+	i.mu.Lock()
 	val, ok := i.entityTagsCache[fmt.Sprintf("%s:%s", iamEntityType, entityName)]
+	i.mu.Unlock()
 
 	if ok {
 		return val, nil
@@ -102,6 +108,8 @@ func (i *IdentityTagsBuilder) GetIdentityTags(identity string) (tags []*iam.Tag,
 		return nil, errors.New("unsupported IAM entity type")
 	}
 
+	i.mu.Lock()
 	i.entityTagsCache[fmt.Sprintf("%s:%s", iamEntityType, entityName)] = tags
+	i.mu.Unlock()
 	return tags, nil
 }
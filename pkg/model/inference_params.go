@@ -0,0 +1,144 @@
+package model
+
+// InferenceParams are the inference parameters of a request, normalized across
+// providers. Only allowlisted parameters are extracted, never prompt text.
+type InferenceParams struct {
+	MaxTokens     int      `json:"maxTokens,omitempty" parquet:"maxTokens,optional"`
+	Temperature   *float64 `json:"temperature,omitempty" parquet:"temperature,optional"`
+	TopP          *float64 `json:"topP,omitempty" parquet:"topP,optional"`
+	TopK          *int     `json:"topK,omitempty" parquet:"topK,optional"`
+	StopSequences []string `json:"stopSequences,omitempty" parquet:"stopSequences,list"`
+}
+
+// inferenceParamPaths are the paths of the allowlisted parameters in a request body
+type inferenceParamPaths struct {
+	maxTokens     []string
+	temperature   []string
+	topP          []string
+	topK          []string
+	stopSequences []string
+}
+
+// converseInferenceParamPaths are the paths of the parameters in Converse requests,
+// which have the same inference configuration for every model
+var converseInferenceParamPaths = inferenceParamPaths{
+	maxTokens:     []string{"inferenceConfig.maxTokens"},
+	temperature:   []string{"inferenceConfig.temperature"},
+	topP:          []string{"inferenceConfig.topP"},
+	topK:          []string{"additionalModelRequestFields.top_k", "additionalModelRequestFields.topK", "additionalModelRequestFields.inferenceConfig.topK"},
+	stopSequences: []string{"inferenceConfig.stopSequences"},
+}
+
+// providerInferenceParamPaths are the paths of the parameters in the request bodies of
+// the model providers, keyed by model ID prefix like providerParsers
+var providerInferenceParamPaths = map[string]inferenceParamPaths{
+	"amazon.titan-text": {
+		maxTokens:     []string{"textGenerationConfig.maxTokenCount"},
+		temperature:   []string{"textGenerationConfig.temperature"},
+		topP:          []string{"textGenerationConfig.topP"},
+		stopSequences: []string{"textGenerationConfig.stopSequences"},
+	},
+	"amazon.nova": {
+		maxTokens:     []string{"inferenceConfig.max_new_tokens", "inferenceConfig.maxTokens"},
+		temperature:   []string{"inferenceConfig.temperature"},
+		topP:          []string{"inferenceConfig.top_p", "inferenceConfig.topP"},
+		topK:          []string{"inferenceConfig.top_k", "inferenceConfig.topK"},
+		stopSequences: []string{"inferenceConfig.stopSequences"},
+	},
+	"anthropic.": {
+		maxTokens:     []string{"max_tokens", "max_tokens_to_sample"},
+		temperature:   []string{"temperature"},
+		topP:          []string{"top_p"},
+		topK:          []string{"top_k"},
+		stopSequences: []string{"stop_sequences"},
+	},
+	"meta.": {
+		maxTokens:   []string{"max_gen_len"},
+		temperature: []string{"temperature"},
+		topP:        []string{"top_p"},
+	},
+	"mistral.": {
+		maxTokens:     []string{"max_tokens"},
+		temperature:   []string{"temperature"},
+		topP:          []string{"top_p"},
+		topK:          []string{"top_k"},
+		stopSequences: []string{"stop"},
+	},
+	"cohere.command": {
+		maxTokens:     []string{"max_tokens"},
+		temperature:   []string{"temperature"},
+		topP:          []string{"p"},
+		topK:          []string{"k"},
+		stopSequences: []string{"stop_sequences"},
+	},
+	"ai21.": {
+		maxTokens:     []string{"maxTokens", "max_tokens"},
+		temperature:   []string{"temperature"},
+		topP:          []string{"topP", "top_p"},
+		stopSequences: []string{"stopSequences", "stop"},
+	},
+}
+
+// extractInferenceParams returns the allowlisted inference parameters of the request
+// body of operation, or nil when the request has none
+func extractInferenceParams(operation, modelID string, inputBody any) *InferenceParams {
+	var paths inferenceParamPaths
+	switch operation {
+	case OperationConverse, OperationConverseStream:
+		paths = converseInferenceParamPaths
+	case OperationInvokeModel, OperationInvokeModelWithResponseStream, OperationBatchInference:
+		var ok bool
+		if paths, ok = lookupModelPrefix(providerInferenceParamPaths, modelID); !ok {
+			return nil
+		}
+	default:
+		return nil
+	}
+
+	params := &InferenceParams{
+		MaxTokens:     int(jsonNumber(inputBody, paths.maxTokens...)),
+		Temperature:   jsonOptionalNumber(inputBody, paths.temperature...),
+		TopP:          jsonOptionalNumber(inputBody, paths.topP...),
+		StopSequences: jsonStrings(inputBody, paths.stopSequences...),
+	}
+	if topK := jsonOptionalNumber(inputBody, paths.topK...); topK != nil {
+		k := int(*topK)
+		params.TopK = &k
+	}
+
+	if params.MaxTokens == 0 && params.Temperature == nil && params.TopP == nil && params.TopK == nil && len(params.StopSequences) == 0 {
+		return nil
+	}
+	return params
+}
+
+// jsonOptionalNumber returns the first number found at paths, or nil when there is
+// none, so that zero values are kept
+func jsonOptionalNumber(body any, paths ...string) *float64 {
+	for _, path := range paths {
+		if value, ok := jsonValue(body, path).(float64); ok {
+			return &value
+		}
+	}
+	return nil
+}
+
+// jsonStrings returns the strings of the first array found at paths, or the string
+// found at a path, like OpenAI style stop parameters
+func jsonStrings(body any, paths ...string) []string {
+	for _, path := range paths {
+		switch value := jsonValue(body, path).(type) {
+		case []any:
+			var strings []string
+			for _, element := range value {
+				if s, ok := element.(string); ok {
+					strings = append(strings, s)
+				}
+			}
+			return strings
+		case string:
+			return []string{value}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,71 @@
+package model
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func TestExtractInferenceParams(t *testing.T) {
+	temperature, topP, topK := 0.5, 0.9, 250
+
+	tests := []struct {
+		operation string
+		modelID   string
+		inputBody string
+		wanted    *InferenceParams
+	}{
+		{
+			operation: OperationConverse,
+			modelID:   "anthropic.claude-3-haiku-20240307-v1:0",
+			inputBody: `{"messages": [{"role": "user", "content": [{"text": "secret"}]}], "inferenceConfig": {"maxTokens": 512, "temperature": 0.5, "stopSequences": ["\n\nHuman:"]}, "additionalModelRequestFields": {"top_k": 250}}`,
+			wanted:    &InferenceParams{MaxTokens: 512, Temperature: &temperature, TopK: &topK, StopSequences: []string{"\n\nHuman:"}},
+		},
+		{
+			operation: OperationInvokeModel,
+			modelID:   "anthropic.claude-3-haiku-20240307-v1:0",
+			inputBody: `{"anthropic_version": "bedrock-2023-05-31", "max_tokens": 4096, "top_p": 0.9, "messages": [{"role": "user", "content": "secret"}]}`,
+			wanted:    &InferenceParams{MaxTokens: 4096, TopP: &topP},
+		},
+		{
+			operation: OperationInvokeModelWithResponseStream,
+			modelID:   "amazon.titan-text-express-v1",
+			inputBody: `{"inputText": "secret", "textGenerationConfig": {"maxTokenCount": 300, "temperature": 0.5, "stopSequences": []}}`,
+			wanted:    &InferenceParams{MaxTokens: 300, Temperature: &temperature},
+		},
+		{
+			operation: OperationBatchInference,
+			modelID:   "meta.llama3-8b-instruct-v1:0",
+			inputBody: `{"prompt": "secret", "max_gen_len": 128, "temperature": 0.5}`,
+			wanted:    &InferenceParams{MaxTokens: 128, Temperature: &temperature},
+		},
+		{
+			operation: OperationInvokeModel,
+			modelID:   "mistral.mistral-7b-instruct-v0:2",
+			inputBody: `{"prompt": "secret", "max_tokens": 200, "stop": "</s>"}`,
+			wanted:    &InferenceParams{MaxTokens: 200, StopSequences: []string{"</s>"}},
+		},
+		{
+			operation: OperationInvokeModel,
+			modelID:   "anthropic.claude-v2",
+			inputBody: `{"prompt": "secret"}`,
+		},
+		{
+			operation: OperationInvokeModel,
+			modelID:   "stability.stable-diffusion-xl-v1",
+			inputBody: `{"text_prompts": [{"text": "secret"}], "steps": 50}`,
+		},
+	}
+
+	for _, test := range tests {
+		var inputBody any
+		if err := json.Unmarshal([]byte(test.inputBody), &inputBody); err != nil {
+			t.Fatal(err)
+		}
+
+		got := extractInferenceParams(test.operation, test.modelID, inputBody)
+		if !reflect.DeepEqual(got, test.wanted) {
+			t.Errorf("%s %s: got %+v, wanted %+v", test.operation, test.modelID, got, test.wanted)
+		}
+	}
+}
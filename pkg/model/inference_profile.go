@@ -0,0 +1,147 @@
+package model
+
+import (
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws/arn"
+)
+
+// Geography prefixes of the IDs of system-defined, cross-region inference profiles,
+// like us.anthropic.claude-3-5-sonnet-20240620-v1:0
+var inferenceProfileGeographies = map[string]bool{
+	"us":     true,
+	"us-gov": true,
+	"eu":     true,
+	"apac":   true,
+	"ca":     true,
+	"jp":     true,
+	"au":     true,
+	"global": true,
+}
+
+// InferenceProfile is an inference profile invoked in place of a model. System-defined
+// profiles route requests across the regions of a geography; application profiles
+// track the usage of a model, in one or more regions.
+type InferenceProfile struct {
+	ID        string
+	Arn       string
+	Geography string
+	// ModelID is the base model of the profile, without its version suffix
+	ModelID string
+}
+
+// InferenceProfileResolver looks up application inference profiles by ARN, since
+// their base model can't be derived from the ARN
+type InferenceProfileResolver interface {
+	GetInferenceProfile(profileArn string) (*InferenceProfile, error)
+}
+
+// parseSystemInferenceProfile parses the ID or ARN of a system-defined inference
+// profile. It returns false for other model IDs.
+func parseSystemInferenceProfile(modelID string) (*InferenceProfile, bool) {
+	profile := &InferenceProfile{ID: modelID}
+	if arn.IsARN(modelID) {
+		modelIDARN, err := arn.Parse(modelID)
+		if err != nil || !strings.HasPrefix(modelIDARN.Resource, "inference-profile/") {
+			return nil, false
+		}
+		profile.Arn = modelID
+		profile.ID = strings.TrimPrefix(modelIDARN.Resource, "inference-profile/")
+	}
+
+	geography, model, ok := strings.Cut(profile.ID, ".")
+	if !ok || !inferenceProfileGeographies[geography] {
+		return nil, false
+	}
+
+	profile.Geography = geography
+	profile.ModelID = baseModelID(model)
+	return profile, true
+}
+
+// isApplicationInferenceProfileArn reports whether modelID is the ARN of an
+// application inference profile
+func isApplicationInferenceProfileArn(modelID string) bool {
+	modelIDARN, err := arn.Parse(modelID)
+	return err == nil && strings.HasPrefix(modelIDARN.Resource, "application-inference-profile/")
+}
+
+// resolveInferenceProfile returns the inference profile invoked as modelID, or nil
+// when modelID is not an inference profile. Application profiles that can't be
+// resolved are returned without a base model.
+func (m *MetadataGenerator) resolveInferenceProfile(modelID string) *InferenceProfile {
+	if profile, ok := parseSystemInferenceProfile(modelID); ok {
+		return profile
+	}
+	if !isApplicationInferenceProfileArn(modelID) {
+		return nil
+	}
+
+	if m.inferenceProfiles != nil {
+		profile, err := m.inferenceProfiles.GetInferenceProfile(modelID)
+		if err == nil {
+			return profile
+		}
+		log.Printf("unable to resolve inference profile %s, %v\n", modelID, err)
+	}
+
+	// Without its base model the invocation can't be priced
+	return &InferenceProfile{ID: modelID[strings.LastIndex(modelID, "/")+1:], Arn: modelID}
+}
+
+// baseModelID returns the model ID of a foundation model ID or ARN, without its
+// version suffix
+func baseModelID(modelID string) string {
+	if modelIDARN, err := arn.Parse(modelID); err == nil {
+		modelID = modelIDARN.Resource[strings.Index(modelIDARN.Resource, "/")+1:]
+	}
+	return strings.Split(modelID, ":")[0]
+}
+
+// regionGeography returns the inference profile geography of a region
+func regionGeography(region string) string {
+	if strings.HasPrefix(region, "us-gov-") {
+		return "us-gov"
+	}
+	if strings.HasPrefix(region, "ap-") {
+		return "apac"
+	}
+	geography, _, _ := strings.Cut(region, "-")
+	return geography
+}
+
+// modelsGeography returns the geography that the models of an application inference
+// profile span: empty for a single region, and global across geographies
+func modelsGeography(modelArns []string) string {
+	regions := make(map[string]bool)
+	geographies := make(map[string]bool)
+	for _, modelArn := range modelArns {
+		region, err := arnRegion(modelArn)
+		if err != nil {
+			continue
+		}
+		regions[region] = true
+		geographies[regionGeography(region)] = true
+	}
+
+	switch {
+	case len(regions) < 2:
+		return ""
+	case len(geographies) > 1:
+		return "global"
+	}
+	for geography := range geographies {
+		return geography
+	}
+	return ""
+}
+
+func arnRegion(resourceArn string) (string, error) {
+	parsedArn, err := arn.Parse(resourceArn)
+	if err != nil {
+		return "", fmt.Errorf("invalid ARN %q, %v", resourceArn, err)
+	}
+	return parsedArn.Region, nil
+}
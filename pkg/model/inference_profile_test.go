@@ -0,0 +1,178 @@
+package model
+
+import (
+	"errors"
+	"testing"
+)
+
+type fakeInferenceProfileResolver map[string]*InferenceProfile
+
+func (f fakeInferenceProfileResolver) GetInferenceProfile(profileArn string) (*InferenceProfile, error) {
+	profile, ok := f[profileArn]
+	if !ok {
+		return nil, errors.New("inference profile not found")
+	}
+	return profile, nil
+}
+
+func TestParseSystemInferenceProfile(t *testing.T) {
+	tests := map[string]InferenceProfile{
+		"us.anthropic.claude-3-5-sonnet-20240620-v1:0": {
+			ID:        "us.anthropic.claude-3-5-sonnet-20240620-v1:0",
+			Geography: "us",
+			ModelID:   "anthropic.claude-3-5-sonnet-20240620-v1",
+		},
+		"arn:aws:bedrock:eu-central-1:893487256304:inference-profile/eu.anthropic.claude-3-haiku-20240307-v1:0": {
+			ID:        "eu.anthropic.claude-3-haiku-20240307-v1:0",
+			Arn:       "arn:aws:bedrock:eu-central-1:893487256304:inference-profile/eu.anthropic.claude-3-haiku-20240307-v1:0",
+			Geography: "eu",
+			ModelID:   "anthropic.claude-3-haiku-20240307-v1",
+		},
+		"us-gov.meta.llama3-8b-instruct-v1:0": {
+			ID:        "us-gov.meta.llama3-8b-instruct-v1:0",
+			Geography: "us-gov",
+			ModelID:   "meta.llama3-8b-instruct-v1",
+		},
+	}
+
+	for modelID, wanted := range tests {
+		profile, ok := parseSystemInferenceProfile(modelID)
+		if !ok {
+			t.Errorf("%s: expected an inference profile", modelID)
+			continue
+		}
+		if *profile != wanted {
+			t.Errorf("got %+v, wanted %+v", *profile, wanted)
+		}
+	}
+
+	for _, modelID := range []string{
+		"anthropic.claude-3-5-sonnet-20240620-v1:0",
+		"arn:aws:bedrock:us-east-1::foundation-model/anthropic.claude-v2",
+		"arn:aws:bedrock:us-east-1:893487256304:application-inference-profile/a1b2c3d4e5f6",
+	} {
+		if _, ok := parseSystemInferenceProfile(modelID); ok {
+			t.Errorf("%s: expected no system-defined inference profile", modelID)
+		}
+	}
+}
+
+func TestModelsGeography(t *testing.T) {
+	tests := []struct {
+		modelArns []string
+		wanted    string
+	}{
+		{
+			modelArns: []string{"arn:aws:bedrock:us-east-1::foundation-model/anthropic.claude-3-haiku-20240307-v1:0"},
+			wanted:    "",
+		},
+		{
+			modelArns: []string{
+				"arn:aws:bedrock:ap-northeast-1::foundation-model/anthropic.claude-3-haiku-20240307-v1:0",
+				"arn:aws:bedrock:ap-southeast-2::foundation-model/anthropic.claude-3-haiku-20240307-v1:0",
+			},
+			wanted: "apac",
+		},
+		{
+			modelArns: []string{
+				"arn:aws:bedrock:us-east-1::foundation-model/anthropic.claude-3-haiku-20240307-v1:0",
+				"arn:aws:bedrock:eu-west-1::foundation-model/anthropic.claude-3-haiku-20240307-v1:0",
+			},
+			wanted: "global",
+		},
+	}
+
+	for _, test := range tests {
+		if got := modelsGeography(test.modelArns); got != test.wanted {
+			t.Errorf("got %q, wanted %q", got, test.wanted)
+		}
+	}
+}
+
+func TestMetadataGenerator_GenerateModelInvocationLogMetadataInferenceProfile(t *testing.T) {
+	applicationProfileArn := "arn:aws:bedrock:us-east-1:893487256304:application-inference-profile/a1b2c3d4e5f6"
+	inferenceProfiles := fakeInferenceProfileResolver{
+		applicationProfileArn: {
+			ID:        "a1b2c3d4e5f6",
+			Arn:       applicationProfileArn,
+			Geography: "us",
+			ModelID:   "anthropic.claude-3-haiku-20240307-v1",
+		},
+	}
+	modelMetaDataGenerator := newTestGenerator(t, GeneratorOptions{InferenceProfiles: inferenceProfiles})
+
+	tests := []struct {
+		modelID        string
+		wantedModelID  string
+		wantedID       string
+		wantedGeo      string
+		wantedModel    string
+		wantedCostUSD  float64
+		wantedProvider string
+	}{
+		{
+			modelID:        "us.anthropic.claude-3-5-sonnet-20240620-v1:0",
+			wantedModelID:  "anthropic.claude-3-5-sonnet-20240620-v1",
+			wantedID:       "us.anthropic.claude-3-5-sonnet-20240620-v1:0",
+			wantedGeo:      "us",
+			wantedModel:    "Claude 3.5 Sonnet",
+			wantedCostUSD:  0.003,
+			wantedProvider: "Anthropic",
+		},
+		{
+			modelID:        applicationProfileArn,
+			wantedModelID:  "anthropic.claude-3-haiku-20240307-v1",
+			wantedID:       "a1b2c3d4e5f6",
+			wantedGeo:      "us",
+			wantedModel:    "Claude 3 Haiku",
+			wantedCostUSD:  0.00025,
+			wantedProvider: "Anthropic",
+		},
+		{
+			modelID:       "arn:aws:bedrock:us-east-1:893487256304:application-inference-profile/unknown",
+			wantedModelID: "unknown",
+			wantedID:      "unknown",
+		},
+	}
+
+	for _, test := range tests {
+		invocationLog := &InvocationLog{
+			Region:  "us-east-1",
+			ModelID: test.modelID,
+		}
+		invocationLog.Input.InputTokenCount = 1000
+
+		metadata, err := modelMetaDataGenerator.GenerateModelInvocationLogMetadata(invocationLog)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if metadata.ModelID != test.wantedModelID {
+			t.Errorf("got %q, wanted %q", metadata.ModelID, test.wantedModelID)
+		}
+		if metadata.InferenceProfileID != test.wantedID {
+			t.Errorf("got %q, wanted %q", metadata.InferenceProfileID, test.wantedID)
+		}
+		if metadata.InferenceProfileGeography != test.wantedGeo {
+			t.Errorf("got %q, wanted %q", metadata.InferenceProfileGeography, test.wantedGeo)
+		}
+		if metadata.ModelName != test.wantedModel || metadata.ModelProvider != test.wantedProvider {
+			t.Errorf("got %q by %q, wanted %q by %q", metadata.ModelName, metadata.ModelProvider, test.wantedModel, test.wantedProvider)
+		}
+		if metadata.InputTokenCostUSD != test.wantedCostUSD {
+			t.Errorf("got %f, wanted %f", metadata.InputTokenCostUSD, test.wantedCostUSD)
+		}
+	}
+}
+
+func TestMetadataGenerator_GenerateModelInvocationLogMetadataModelARNWithoutResourceID(t *testing.T) {
+	modelMetaDataGenerator := newTestGenerator(t, GeneratorOptions{InferenceProfiles: fakeInferenceProfileResolver{}})
+
+	invocationLog := &InvocationLog{
+		Region:  "us-east-1",
+		ModelID: "arn:aws:bedrock:us-east-1:893487256304:custom-model",
+	}
+	if _, err := modelMetaDataGenerator.GenerateModelInvocationLogMetadata(invocationLog); err == nil {
+		t.Errorf("got no error, wanted an error for a model ARN without a resource ID")
+	}
+}
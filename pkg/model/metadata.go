@@ -1,35 +1,82 @@
 package model
 
 import (
-	"encoding/json"
+	"fmt"
 	"github.com/aws/aws-sdk-go/aws/arn"
 	"log"
 	"strings"
 )
 
 type MetadataGenerator struct {
-	modelCost       *CostEstimator
-	carbonFootprint *CarbonFootprintEstimator
-	identity        *IdentityTagsBuilder
+	modelCost         *CostEstimator
+	carbonFootprint   *CarbonFootprintEstimator
+	identity          *IdentityTagsBuilder
+	provisionedModels map[string]ProvisionedModel
+	batchJobs         BatchJobResolver
+	inferenceProfiles InferenceProfileResolver
+	resourceTags      ResourceTagsResolver
+	redaction         *RedactionPolicy
+	fingerprints      *Fingerprinter
 }
 
-func NewMetadataGenerator(modelCost *CostEstimator, carbonFootprint *CarbonFootprintEstimator, identity *IdentityTagsBuilder) *MetadataGenerator {
+// GeneratorOptions configures the optional features of a metadata generator. Every
+// field may be left unset.
+type GeneratorOptions struct {
+	// ProvisionedModels maps provisioned model ARNs to their base models
+	ProvisionedModels map[string]ProvisionedModel
+	// BatchJobs resolves the jobs of batch inference output
+	BatchJobs BatchJobResolver
+	// InferenceProfiles and ResourceTags resolve application inference profiles and
+	// their tags
+	InferenceProfiles InferenceProfileResolver
+	ResourceTags      ResourceTagsResolver
+	// Redaction decides what is retained beyond the metadata; without it nothing is
+	Redaction *RedactionPolicy
+	// Fingerprints fingerprints prompts and completions; without it they are not
+	Fingerprints *Fingerprinter
+}
+
+func NewMetadataGenerator(modelCost *CostEstimator, carbonFootprint *CarbonFootprintEstimator, identity *IdentityTagsBuilder, options GeneratorOptions) *MetadataGenerator {
 	return &MetadataGenerator{
-		modelCost:       modelCost,
-		carbonFootprint: carbonFootprint,
-		identity:        identity,
+		modelCost:         modelCost,
+		carbonFootprint:   carbonFootprint,
+		identity:          identity,
+		provisionedModels: options.ProvisionedModels,
+		batchJobs:         options.BatchJobs,
+		inferenceProfiles: options.InferenceProfiles,
+		resourceTags:      options.ResourceTags,
+		redaction:         options.Redaction,
+		fingerprints:      options.Fingerprints,
 	}
 }
 
 func (m *MetadataGenerator) GenerateModelInvocationLogMetadata(modelInvocationLog *InvocationLog) (modelInvocationLogMetadata *InvocationLogMetadata, err error) {
-	var modelId string
-	if arn.IsARN(modelInvocationLog.ModelID) {
+	var modelId, provisionedModelArn string
+	inferenceProfile := m.resolveInferenceProfile(modelInvocationLog.ModelID)
+	if provisionedModel, ok := m.provisionedModels[modelInvocationLog.ModelID]; ok {
+		modelId = provisionedModel.ModelID
+		provisionedModelArn = modelInvocationLog.ModelID
+	} else if inferenceProfile != nil {
+		modelId = inferenceProfile.ModelID
+		if modelId == "" {
+			modelId = inferenceProfile.ID
+		}
+	} else if arn.IsARN(modelInvocationLog.ModelID) {
 		modelIdARN, err := arn.Parse(modelInvocationLog.ModelID)
 		if err != nil {
 			return modelInvocationLogMetadata, err
 		}
-		modelId = strings.Split(modelIdARN.Resource, "/")[1]
-		modelId = strings.Split(modelId, ":")[0]
+		_, resourceID, found := strings.Cut(modelIdARN.Resource, "/")
+		if !found {
+			return modelInvocationLogMetadata, fmt.Errorf("unsupported model ARN %s", modelInvocationLog.ModelID)
+		}
+		modelId, _, _ = strings.Cut(resourceID, "/")
+		modelId, _, _ = strings.Cut(modelId, ":")
+		if isProvisionedModelArn(modelInvocationLog.ModelID) {
+			// Without a mapping to its base model the invocation can't be priced
+			log.Printf("unknown provisioned model %s\n", modelInvocationLog.ModelID)
+			provisionedModelArn = modelInvocationLog.ModelID
+		}
 	} else {
 		if strings.Contains(modelInvocationLog.ModelID, ":") {
 			modelId = strings.Split(modelInvocationLog.ModelID, ":")[0]
@@ -38,39 +85,66 @@ func (m *MetadataGenerator) GenerateModelInvocationLogMetadata(modelInvocationLo
 		}
 	}
 
+	pricingMode := PricingModeOnDemand
+	if provisionedModelArn != "" {
+		pricingMode = PricingModeProvisioned
+	} else if modelInvocationLog.PricingMode != "" {
+		pricingMode = modelInvocationLog.PricingMode
+	}
+
 	modelInvocationLogMetadata = &InvocationLogMetadata{
-		ModelID:           modelId,
-		Timestamp:         modelInvocationLog.Timestamp,
-		AccountID:         modelInvocationLog.AccountID,
-		Region:            modelInvocationLog.Region,
-		RequestID:         modelInvocationLog.RequestID,
-		Operation:         modelInvocationLog.Operation,
-		Identity:          modelInvocationLog.Identity,
-		InputContentType:  modelInvocationLog.Input.InputContentType,
-		OutputContentType: modelInvocationLog.Output.OutputContentType,
-		InputTokenCount:   modelInvocationLog.Input.InputTokenCount,
-		OutputTokenCount:  modelInvocationLog.Output.OutputTokenCount,
+		ModelID:                   modelId,
+		ProvisionedModelArn:       provisionedModelArn,
+		PricingMode:               pricingMode,
+		Timestamp:                 modelInvocationLog.Timestamp,
+		AccountID:                 modelInvocationLog.AccountID,
+		Region:                    modelInvocationLog.Region,
+		RequestID:                 modelInvocationLog.RequestID,
+		Operation:                 modelInvocationLog.Operation,
+		Identity:                  modelInvocationLog.Identity,
+		InputContentType:          modelInvocationLog.Input.InputContentType,
+		OutputContentType:         modelInvocationLog.Output.OutputContentType,
+		InputTokenCount:           modelInvocationLog.Input.InputTokenCount,
+		OutputTokenCount:          modelInvocationLog.Output.OutputTokenCount,
+		CacheReadInputTokenCount:  modelInvocationLog.Input.CacheReadInputTokenCount,
+		CacheWriteInputTokenCount: modelInvocationLog.Input.CacheWriteInputTokenCount,
+	}
+
+	// Token counts missing from the log are taken from the output body, along with the
+	// stop reason and number of outputs
+	usage := parseOperationUsage(modelInvocationLog.Operation, modelId, modelInvocationLog.Output.OutputBodyJSON)
+	if modelInvocationLogMetadata.InputTokenCount == 0 {
+		modelInvocationLogMetadata.InputTokenCount = usage.InputTokenCount
+	}
+	if modelInvocationLogMetadata.OutputTokenCount == 0 {
+		modelInvocationLogMetadata.OutputTokenCount = usage.OutputTokenCount
+	}
+	if modelInvocationLogMetadata.CacheReadInputTokenCount == 0 {
+		modelInvocationLogMetadata.CacheReadInputTokenCount = usage.CacheReadInputTokenCount
+	}
+	if modelInvocationLogMetadata.CacheWriteInputTokenCount == 0 {
+		modelInvocationLogMetadata.CacheWriteInputTokenCount = usage.CacheWriteInputTokenCount
+	}
+	modelInvocationLogMetadata.StopReason = normalizeStopReason(usage.StopReason)
+	modelInvocationLogMetadata.Truncated = modelInvocationLogMetadata.StopReason == StopReasonMaxTokens
+	modelInvocationLogMetadata.OutputCount = usage.OutputCount
+	modelInvocationLogMetadata.InferenceParams = extractInferenceParams(modelInvocationLog.Operation, modelId, modelInvocationLog.Input.InputBodyJSON)
+
+	if inferenceProfile != nil {
+		modelInvocationLogMetadata.InferenceProfileID = inferenceProfile.ID
+		modelInvocationLogMetadata.InferenceProfileGeography = inferenceProfile.Geography
 	}
 
 	modelInvocationLogMetadata = m.modelCost.EstimateModelInvocationCost(modelInvocationLogMetadata)
 
-	// parse additional latency metrics related to streaming operation
-	if modelInvocationLog.Operation == "InvokeModelWithResponseStream" {
-		outputBody, err := json.Marshal(modelInvocationLog.Output.OutputBodyJSON)
-		if err == nil {
-			outputBodyPayload := make([]InvocationLogOutputBodyJSON, 0)
-			err = json.Unmarshal(outputBody, &outputBodyPayload)
-			if err == nil {
-				for _, output := range outputBodyPayload {
-					if output.AmazonBedrockInvocationMetrics.InvocationLatency != 0 && output.AmazonBedrockInvocationMetrics.FirstByteLatency != 0 {
-						modelInvocationLogMetadata.InvocationLatency = output.AmazonBedrockInvocationMetrics.InvocationLatency
-						modelInvocationLogMetadata.FirstByteLatency = output.AmazonBedrockInvocationMetrics.FirstByteLatency
-						modelInvocationLogMetadata = m.carbonFootprint.EstimateModelInvocationCarbonFootprint(modelInvocationLogMetadata)
-						break
-					}
-				}
-			}
-		}
+	unitUsage := extractUnitUsage(modelInvocationLog.Input.InputBodyJSON, modelInvocationLog.Output.OutputBodyJSON)
+	modelInvocationLogMetadata = m.modelCost.EstimateModelInvocationUnitCost(modelInvocationLogMetadata, unitUsage)
+
+	// Latency is only reported by some operations
+	if usage.InvocationLatency != 0 {
+		modelInvocationLogMetadata.InvocationLatency = usage.InvocationLatency
+		modelInvocationLogMetadata.FirstByteLatency = usage.FirstByteLatency
+		modelInvocationLogMetadata = m.carbonFootprint.EstimateModelInvocationCarbonFootprint(modelInvocationLogMetadata)
 	}
 
 	// Get IAM identity tags
@@ -83,5 +157,25 @@ func (m *MetadataGenerator) GenerateModelInvocationLogMetadata(modelInvocationLo
 		modelInvocationLogMetadata.IdentityTags = append(modelInvocationLogMetadata.IdentityTags, IdentityTag{Key: *identityTag.Key, Value: *identityTag.Value})
 	}
 
+	// Get the tags of the application inference profile invoked, for cost allocation
+	if m.resourceTags != nil && isApplicationInferenceProfileArn(modelInvocationLog.ModelID) {
+		resourceTags, err := m.resourceTags.GetResourceTags(modelInvocationLog.ModelID)
+		if err != nil {
+			log.Printf("unable to get tags for %s, %v\n", modelInvocationLog.ModelID, err)
+		}
+		modelInvocationLogMetadata.ResourceTags = resourceTags
+	}
+
+	if m.fingerprints != nil {
+		m.fingerprints.apply(modelInvocationLog, modelInvocationLogMetadata)
+	}
+
+	// Redact last, as tags are looked up by the identity ARN
+	if m.redaction != nil {
+		if err := m.redaction.apply(modelInvocationLog, modelInvocationLogMetadata); err != nil {
+			return nil, err
+		}
+	}
+
 	return modelInvocationLogMetadata, nil
 }
@@ -12,6 +12,29 @@ import (
 	"testing"
 )
 
+// newTestGenerator creates a metadata generator pricing the models of models.json,
+// with the given options. The identity tags of the given IAM entities are cached as
+// empty, so they are never looked up.
+func newTestGenerator(t *testing.T, options GeneratorOptions, untaggedEntities ...string) *MetadataGenerator {
+	t.Helper()
+
+	modelsPriceDetails, err := os.ReadFile("../../models.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	modelCostEstimator, err := NewCostEstimator(modelsPriceDetails)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	identityTagsBuilder := NewIdentityTagsBuilder(nil)
+	for _, entity := range untaggedEntities {
+		identityTagsBuilder.entityTagsCache[entity] = nil
+	}
+	return NewMetadataGenerator(modelCostEstimator, NewCarbonFootprintEstimator(400, 768000, 450), identityTagsBuilder, options)
+}
+
 func TestMetadataGenerator_GenerateModelInvocationLogMetadataInvoke(t *testing.T) {
 	input, err := os.Open("test_data/input_invoke.json")
 	if err != nil {
@@ -58,7 +81,7 @@ func TestMetadataGenerator_GenerateModelInvocationLogMetadataInvoke(t *testing.T
 
 	modelCarbonFootprint := NewCarbonFootprintEstimator(400, 768000, 450)
 
-	modelMetaDataGenerator := NewMetadataGenerator(modelCostEstimator, modelCarbonFootprint, identityTagsBuilder)
+	modelMetaDataGenerator := NewMetadataGenerator(modelCostEstimator, modelCarbonFootprint, identityTagsBuilder, GeneratorOptions{})
 	metadata, err := modelMetaDataGenerator.GenerateModelInvocationLogMetadata(&invocationLog)
 
 	if fmt.Sprintf("%2f", metadata.InputTokenCostUSD) != "0.000003" {
@@ -116,7 +139,7 @@ func TestMetadataGenerator_GenerateModelInvocationLogMetadataInvokeStream(t *tes
 
 	modelCarbonFootprint := NewCarbonFootprintEstimator(400, 768000, 450)
 
-	modelMetaDataGenerator := NewMetadataGenerator(modelCostEstimator, modelCarbonFootprint, identityTagsBuilder)
+	modelMetaDataGenerator := NewMetadataGenerator(modelCostEstimator, modelCarbonFootprint, identityTagsBuilder, GeneratorOptions{})
 	metadata, err := modelMetaDataGenerator.GenerateModelInvocationLogMetadata(&invocationLog)
 
 	if fmt.Sprintf("%2f", metadata.InputTokenCostUSD) != "0.000006" {
@@ -135,3 +158,91 @@ func TestMetadataGenerator_GenerateModelInvocationLogMetadataInvokeStream(t *tes
 		t.Errorf("got %2f, wanted %s", metadata.CarbonEmissiongCO2e, "0.484876")
 	}
 }
+
+func TestMetadataGenerator_GenerateModelInvocationLogMetadataImage(t *testing.T) {
+	modelsPriceDetails, err := os.ReadFile("../../models.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	modelCostEstimator, err := NewCostEstimator(modelsPriceDetails)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	iamSess, err := session.NewSession(&aws.Config{
+		Region: aws.String("us-east-1"),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	modelCarbonFootprint := NewCarbonFootprintEstimator(400, 768000, 450)
+	modelMetaDataGenerator := NewMetadataGenerator(modelCostEstimator, modelCarbonFootprint, NewIdentityTagsBuilder(iam.New(iamSess)), GeneratorOptions{})
+
+	tests := []struct {
+		fixture   string
+		unitCount float64
+		unitCost  string
+	}{
+		// Two premium images larger than 512x512
+		{fixture: "test_data/input_invoke_titan_image.json", unitCount: 2, unitCost: "0.024000"},
+		// One 1024x1024 image with more than 50 steps
+		{fixture: "test_data/input_invoke_sdxl.json", unitCount: 1, unitCost: "0.080000"},
+	}
+
+	for _, test := range tests {
+		invocation, err := os.ReadFile(test.fixture)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var invocationLog InvocationLog
+		if err := json.Unmarshal(invocation, &invocationLog); err != nil {
+			t.Fatal(err)
+		}
+
+		metadata, err := modelMetaDataGenerator.GenerateModelInvocationLogMetadata(&invocationLog)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if metadata.PricingUnit != PricingUnitImage {
+			t.Errorf("%s: got %q, wanted %q", test.fixture, metadata.PricingUnit, PricingUnitImage)
+		}
+
+		if metadata.UnitCount != test.unitCount {
+			t.Errorf("%s: got %f, wanted %f", test.fixture, metadata.UnitCount, test.unitCount)
+		}
+
+		if fmt.Sprintf("%f", metadata.UnitCostUSD) != test.unitCost {
+			t.Errorf("%s: got %f, wanted %s", test.fixture, metadata.UnitCostUSD, test.unitCost)
+		}
+
+		if fmt.Sprintf("%f", metadata.TotalCostUSD()) != test.unitCost {
+			t.Errorf("%s: got %f, wanted %s", test.fixture, metadata.TotalCostUSD(), test.unitCost)
+		}
+	}
+}
+
+func TestMetadataGenerator_GenerateModelInvocationLogMetadataPromptCache(t *testing.T) {
+	modelMetaDataGenerator := newTestGenerator(t, GeneratorOptions{})
+
+	var invocationLog InvocationLog
+	err := json.Unmarshal([]byte(`{"region": "us-east-1", "operation": "Converse", "modelId": "anthropic.claude-3-7-sonnet-20250219-v1:0", "input": {"inputTokenCount": 12, "cacheReadInputTokenCount": 2048, "cacheWriteInputTokenCount": 0}, "output": {"outputTokenCount": 100}}`), &invocationLog)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	metadata, err := modelMetaDataGenerator.GenerateModelInvocationLogMetadata(&invocationLog)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if metadata.CacheReadInputTokenCount != 2048 {
+		t.Errorf("got %d, wanted %d", metadata.CacheReadInputTokenCount, 2048)
+	}
+	if metadata.CacheReadInputTokenCostUSD == 0 || metadata.CacheSavingsUSD <= 0 {
+		t.Errorf("got %f cost and %f savings, wanted cache reads priced below input tokens", metadata.CacheReadInputTokenCostUSD, metadata.CacheSavingsUSD)
+	}
+}
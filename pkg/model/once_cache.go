@@ -0,0 +1,36 @@
+package model
+
+import "sync"
+
+// onceCache caches the result of a lookup by key for the run. Concurrent lookups of
+// the same key wait for the first one instead of repeating it, and failures are cached
+// like results, so each key is looked up once. The lock is only held to find the
+// entry of a key, never during a lookup.
+type onceCache[T any] struct {
+	mu      sync.Mutex
+	entries map[string]*onceEntry[T]
+}
+
+type onceEntry[T any] struct {
+	once  sync.Once
+	value T
+	err   error
+}
+
+func (c *onceCache[T]) get(key string, lookup func() (T, error)) (T, error) {
+	c.mu.Lock()
+	if c.entries == nil {
+		c.entries = make(map[string]*onceEntry[T])
+	}
+	entry, ok := c.entries[key]
+	if !ok {
+		entry = &onceEntry[T]{}
+		c.entries[key] = entry
+	}
+	c.mu.Unlock()
+
+	entry.once.Do(func() {
+		entry.value, entry.err = lookup()
+	})
+	return entry.value, entry.err
+}
@@ -0,0 +1,46 @@
+package model
+
+import (
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+func TestOnceCache(t *testing.T) {
+	var cache onceCache[string]
+	var calls atomic.Int32
+	release := make(chan struct{})
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			value, err := cache.get("a", func() (string, error) {
+				calls.Add(1)
+				<-release
+				return "value", nil
+			})
+			if err != nil || value != "value" {
+				t.Errorf("got %q, %v, wanted %q", value, err, "value")
+			}
+		}()
+	}
+
+	// Other keys are looked up while the first lookup is in flight
+	if _, err := cache.get("b", func() (string, error) { return "", errors.New("not found") }); err == nil {
+		t.Errorf("expected an error for a failed lookup")
+	}
+	close(release)
+	wg.Wait()
+
+	if calls.Load() != 1 {
+		t.Errorf("got %d calls, wanted %d", calls.Load(), 1)
+	}
+
+	// Failures are cached
+	if _, err := cache.get("b", func() (string, error) { return "value", nil }); err == nil {
+		t.Errorf("expected the cached error")
+	}
+}
@@ -0,0 +1,157 @@
+package model
+
+// Operations of model invocation logs
+const (
+	OperationInvokeModel                   = "InvokeModel"
+	OperationInvokeModelWithResponseStream = "InvokeModelWithResponseStream"
+	OperationConverse                      = "Converse"
+	OperationConverseStream                = "ConverseStream"
+)
+
+// streamInvocationMetricsPrefix is the path of the invocation metrics in the last chunk
+// of a response stream
+const streamInvocationMetricsPrefix = "amazon-bedrock-invocationMetrics."
+
+// Paths of the prompt cache token counts in any response
+var (
+	cacheReadTokenPaths  = []string{"usage.cache_read_input_tokens", "usage.cacheReadInputTokens", "usage.cacheReadInputTokenCount"}
+	cacheWriteTokenPaths = []string{"usage.cache_creation_input_tokens", "usage.cacheWriteInputTokens", "usage.cacheWriteInputTokenCount"}
+)
+
+// OperationUsage is the usage, stop reason and latency reported in the output body of
+// an operation. OutputCount is the number of generated outputs, such as completions.
+type OperationUsage struct {
+	InputTokenCount           int
+	OutputTokenCount          int
+	CacheReadInputTokenCount  int
+	CacheWriteInputTokenCount int
+	StopReason                string
+	OutputCount               int
+	InvocationLatency         int
+	FirstByteLatency          int
+}
+
+// merge overwrites the usage with the values reported in a later stream chunk
+func (u *OperationUsage) merge(chunk OperationUsage) {
+	if chunk.InputTokenCount != 0 {
+		u.InputTokenCount = chunk.InputTokenCount
+	}
+	if chunk.OutputTokenCount != 0 {
+		u.OutputTokenCount = chunk.OutputTokenCount
+	}
+	if chunk.CacheReadInputTokenCount != 0 {
+		u.CacheReadInputTokenCount = chunk.CacheReadInputTokenCount
+	}
+	if chunk.CacheWriteInputTokenCount != 0 {
+		u.CacheWriteInputTokenCount = chunk.CacheWriteInputTokenCount
+	}
+	if chunk.StopReason != "" {
+		u.StopReason = chunk.StopReason
+	}
+	if chunk.OutputCount > u.OutputCount {
+		u.OutputCount = chunk.OutputCount
+	}
+}
+
+// operationParsers extract the usage from the output body of each operation, given the
+// base model invoked
+var operationParsers = map[string]func(modelID string, outputBody any) OperationUsage{
+	OperationInvokeModel:                   parseInvokeModelOutput,
+	OperationInvokeModelWithResponseStream: parseInvokeModelStreamOutput,
+	OperationConverse:                      parseConverseOutput,
+	OperationConverseStream:                parseConverseStreamOutput,
+	OperationBatchInference:                parseInvokeModelOutput,
+}
+
+// parseOperationUsage returns the usage reported in the output body of operation, or
+// no usage for other operations
+func parseOperationUsage(operation, modelID string, outputBody any) OperationUsage {
+	parser, ok := operationParsers[operation]
+	if !ok {
+		return OperationUsage{}
+	}
+	return parser(modelID, outputBody)
+}
+
+// parseInvokeModelOutput parses the response body of the model provider. Latency is
+// only returned in response headers, which are not logged.
+func parseInvokeModelOutput(modelID string, outputBody any) OperationUsage {
+	if outputBody == nil {
+		return OperationUsage{}
+	}
+
+	usage := providerParser(modelID)(outputBody)
+	usage.CacheReadInputTokenCount = int(jsonNumber(outputBody, cacheReadTokenPaths...))
+	usage.CacheWriteInputTokenCount = int(jsonNumber(outputBody, cacheWriteTokenPaths...))
+	return usage
+}
+
+// parseInvokeModelStreamOutput parses the chunks of a response stream, the last of
+// which carries the invocation metrics
+func parseInvokeModelStreamOutput(modelID string, outputBody any) OperationUsage {
+	var usage OperationUsage
+
+	chunks, _ := outputBody.([]any)
+	for _, chunk := range chunks {
+		usage.merge(parseInvokeModelOutput(modelID, chunk))
+
+		if jsonValue(chunk, streamInvocationMetricsPrefix+"invocationLatency") == nil {
+			continue
+		}
+		usage.merge(OperationUsage{
+			InputTokenCount:           int(jsonNumber(chunk, streamInvocationMetricsPrefix+"inputTokenCount")),
+			OutputTokenCount:          int(jsonNumber(chunk, streamInvocationMetricsPrefix+"outputTokenCount")),
+			CacheReadInputTokenCount:  int(jsonNumber(chunk, streamInvocationMetricsPrefix+"cacheReadInputTokenCount")),
+			CacheWriteInputTokenCount: int(jsonNumber(chunk, streamInvocationMetricsPrefix+"cacheWriteInputTokenCount")),
+		})
+		usage.InvocationLatency = int(jsonNumber(chunk, streamInvocationMetricsPrefix+"invocationLatency"))
+		usage.FirstByteLatency = int(jsonNumber(chunk, streamInvocationMetricsPrefix+"firstByteLatency"))
+	}
+
+	// Chunks are parts of a single output, unless they index several
+	if len(chunks) > 0 && usage.OutputCount == 0 {
+		usage.OutputCount = 1
+	}
+	return usage
+}
+
+// parseConverseOutput parses a Converse response, which has the same usage, stop
+// reason and metrics for every model
+func parseConverseOutput(modelID string, outputBody any) OperationUsage {
+	usage := OperationUsage{
+		InputTokenCount:           int(jsonNumber(outputBody, "usage.inputTokens")),
+		OutputTokenCount:          int(jsonNumber(outputBody, "usage.outputTokens")),
+		CacheReadInputTokenCount:  int(jsonNumber(outputBody, cacheReadTokenPaths...)),
+		CacheWriteInputTokenCount: int(jsonNumber(outputBody, cacheWriteTokenPaths...)),
+		StopReason:                jsonString(outputBody, "stopReason"),
+		InvocationLatency:         int(jsonNumber(outputBody, "metrics.latencyMs")),
+	}
+	if jsonValue(outputBody, "output.message") != nil {
+		usage.OutputCount = 1
+	}
+	return usage
+}
+
+// parseConverseStreamOutput parses the events of a ConverseStream response, whose
+// metadata event has the usage and metrics of a Converse response
+func parseConverseStreamOutput(modelID string, outputBody any) OperationUsage {
+	var usage OperationUsage
+
+	events, _ := outputBody.([]any)
+	for _, event := range events {
+		if metadata := jsonValue(event, "metadata"); metadata != nil {
+			usage.merge(parseConverseOutput(modelID, metadata))
+			usage.InvocationLatency = int(jsonNumber(metadata, "metrics.latencyMs"))
+		} else if jsonValue(event, "usage") != nil {
+			usage.merge(parseConverseOutput(modelID, event))
+			usage.InvocationLatency = int(jsonNumber(event, "metrics.latencyMs"))
+		}
+		if stopReason := jsonString(event, "messageStop.stopReason"); stopReason != "" {
+			usage.StopReason = stopReason
+		}
+		if jsonValue(event, "messageStart") != nil {
+			usage.OutputCount = 1
+		}
+	}
+	return usage
+}
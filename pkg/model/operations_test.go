@@ -0,0 +1,81 @@
+package model
+
+import (
+	"encoding/json"
+	"math"
+	"os"
+	"testing"
+)
+
+// testSageMakerEntity is the IAM entity of the identity of test_data/input_converse.json
+const testSageMakerEntity = "assumed-role:AmazonSageMaker-ExecutionRole-20240210T141891/SageMaker"
+
+func readInvocationLog(t *testing.T, name string) *InvocationLog {
+	t.Helper()
+
+	data, err := os.ReadFile(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var invocationLog InvocationLog
+	if err := json.Unmarshal(data, &invocationLog); err != nil {
+		t.Fatal(err)
+	}
+	return &invocationLog
+}
+
+func TestParseOperationUsage(t *testing.T) {
+	tests := map[string]OperationUsage{
+		"test_data/input_invoke.json":          {InputTokenCount: 4, OutputTokenCount: 160, StopReason: "stop", OutputCount: 1},
+		"test_data/input_invoke_stream.json":   {InputTokenCount: 20, OutputTokenCount: 354, StopReason: "FINISH", OutputCount: 1, InvocationLatency: 8129, FirstByteLatency: 2154},
+		"test_data/input_converse.json":        {InputTokenCount: 16, OutputTokenCount: 24, StopReason: "end_turn", OutputCount: 1, InvocationLatency: 812},
+		"test_data/input_converse_stream.json": {InputTokenCount: 16, OutputTokenCount: 24, StopReason: "end_turn", OutputCount: 1, InvocationLatency: 1045},
+	}
+
+	for name, wanted := range tests {
+		invocationLog := readInvocationLog(t, name)
+		got := parseOperationUsage(invocationLog.Operation, invocationLog.ModelID, invocationLog.Output.OutputBodyJSON)
+		if got != wanted {
+			t.Errorf("%s: got %+v, wanted %+v", name, got, wanted)
+		}
+	}
+
+	if got := parseOperationUsage("ApplyGuardrail", "anthropic.claude-v2", map[string]any{"usage": map[string]any{"inputTokens": 1.0}}); got != (OperationUsage{}) {
+		t.Errorf("got %+v, wanted no usage for an unsupported operation", got)
+	}
+}
+
+func TestParseOperationUsagePromptCache(t *testing.T) {
+	var outputBody any
+	err := json.Unmarshal([]byte(`{"stopReason": "end_turn", "metrics": {"latencyMs": 420}, "usage": {"inputTokens": 12, "outputTokens": 30, "cacheReadInputTokens": 2048, "cacheWriteInputTokens": 512}}`), &outputBody)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	wanted := OperationUsage{InputTokenCount: 12, OutputTokenCount: 30, CacheReadInputTokenCount: 2048, CacheWriteInputTokenCount: 512, StopReason: "end_turn", InvocationLatency: 420}
+	if got := parseOperationUsage(OperationConverse, "anthropic.claude-3-haiku-20240307-v1", outputBody); got != wanted {
+		t.Errorf("got %+v, wanted %+v", got, wanted)
+	}
+}
+
+func TestMetadataGenerator_GenerateModelInvocationLogMetadataConverse(t *testing.T) {
+	modelMetaDataGenerator := newTestGenerator(t, GeneratorOptions{}, testSageMakerEntity)
+
+	for _, name := range []string{"test_data/input_converse.json", "test_data/input_converse_stream.json"} {
+		metadata, err := modelMetaDataGenerator.GenerateModelInvocationLogMetadata(readInvocationLog(t, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if metadata.InvocationLatency == 0 || metadata.FirstByteLatency != 0 {
+			t.Errorf("%s: got %d and %d latency, wanted only the invocation latency", name, metadata.InvocationLatency, metadata.FirstByteLatency)
+		}
+		if metadata.EnergyConsumptionkWh == 0 || metadata.CarbonEmissiongCO2e == 0 {
+			t.Errorf("%s: got no carbon footprint estimate", name)
+		}
+		if math.Abs(metadata.InputTokenCostUSD-0.000004) > 1e-12 || math.Abs(metadata.OutputTokenCostUSD-0.00003) > 1e-12 {
+			t.Errorf("%s: got %f and %f, wanted %f and %f", name, metadata.InputTokenCostUSD, metadata.OutputTokenCostUSD, 0.000004, 0.00003)
+		}
+	}
+}
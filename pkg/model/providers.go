@@ -0,0 +1,132 @@
+package model
+
+import "strings"
+
+// providerParsers parse the response bodies, or response stream chunks, of the model
+// providers. They are keyed by model ID prefix, and the longest matching prefix is used.
+var providerParsers = map[string]func(outputBody any) OperationUsage{
+	"amazon.titan-text": parseTitanTextOutput,
+	"amazon.nova":       parseNovaOutput,
+	"anthropic.":        parseAnthropicOutput,
+	"meta.":             parseLlamaOutput,
+	"mistral.":          parseMistralOutput,
+	"cohere.command":    parseCohereOutput,
+	"ai21.":             parseAI21Output,
+}
+
+// providerParser returns the parser of the responses of the base model modelID, or a
+// parser of the common token count fields for other models
+func providerParser(modelID string) func(outputBody any) OperationUsage {
+	if parser, ok := lookupModelPrefix(providerParsers, modelID); ok {
+		return parser
+	}
+	return parseGenericOutput
+}
+
+// lookupModelPrefix returns the value of the longest model ID prefix of modelID
+func lookupModelPrefix[T any](byPrefix map[string]T, modelID string) (T, bool) {
+	var value T
+	longestPrefix, found := "", false
+	for prefix, prefixValue := range byPrefix {
+		if strings.HasPrefix(modelID, prefix) && len(prefix) > len(longestPrefix) {
+			value, longestPrefix, found = prefixValue, prefix, true
+		}
+	}
+	return value, found
+}
+
+func parseGenericOutput(outputBody any) OperationUsage {
+	return OperationUsage{
+		InputTokenCount:  int(jsonNumber(outputBody, "usage.input_tokens", "usage.inputTokens", "usage.prompt_tokens")),
+		OutputTokenCount: int(jsonNumber(outputBody, "usage.output_tokens", "usage.outputTokens", "usage.completion_tokens")),
+	}
+}
+
+// parseTitanTextOutput parses {"inputTextTokenCount", "results": [{"tokenCount",
+// "completionReason"}]}, or a stream chunk with the fields of a result
+func parseTitanTextOutput(outputBody any) OperationUsage {
+	return OperationUsage{
+		InputTokenCount:  int(jsonNumber(outputBody, "inputTextTokenCount")),
+		OutputTokenCount: int(jsonNumber(outputBody, "results.0.tokenCount", "totalOutputTextTokenCount")),
+		StopReason:       jsonString(outputBody, "results.0.completionReason", "completionReason"),
+		OutputCount:      jsonArrayLength(outputBody, "results"),
+	}
+}
+
+// parseNovaOutput parses the Nova messages API response, or its stream events
+func parseNovaOutput(outputBody any) OperationUsage {
+	usage := OperationUsage{
+		InputTokenCount:  int(jsonNumber(outputBody, "usage.inputTokens", "metadata.usage.inputTokens")),
+		OutputTokenCount: int(jsonNumber(outputBody, "usage.outputTokens", "metadata.usage.outputTokens")),
+		StopReason:       jsonString(outputBody, "stopReason", "messageStop.stopReason"),
+	}
+	if jsonValue(outputBody, "output.message") != nil {
+		usage.OutputCount = 1
+	}
+	return usage
+}
+
+// parseAnthropicOutput parses the Messages and Text Completions API responses, or the
+// message_start and message_delta events of their streams
+func parseAnthropicOutput(outputBody any) OperationUsage {
+	usage := OperationUsage{
+		InputTokenCount:  int(jsonNumber(outputBody, "usage.input_tokens", "message.usage.input_tokens")),
+		OutputTokenCount: int(jsonNumber(outputBody, "usage.output_tokens")),
+		StopReason:       jsonString(outputBody, "stop_reason", "delta.stop_reason"),
+	}
+	if jsonValue(outputBody, "content") != nil || jsonValue(outputBody, "completion") != nil {
+		usage.OutputCount = 1
+	}
+	return usage
+}
+
+// parseLlamaOutput parses {"generation", "prompt_token_count", "generation_token_count",
+// "stop_reason"}, which stream chunks share
+func parseLlamaOutput(outputBody any) OperationUsage {
+	usage := OperationUsage{
+		InputTokenCount:  int(jsonNumber(outputBody, "prompt_token_count")),
+		OutputTokenCount: int(jsonNumber(outputBody, "generation_token_count")),
+		StopReason:       jsonString(outputBody, "stop_reason"),
+	}
+	if jsonValue(outputBody, "generation") != nil {
+		usage.OutputCount = 1
+	}
+	return usage
+}
+
+// parseMistralOutput parses {"outputs": [{"text", "stop_reason"}]}, or the chat
+// completion {"choices": [{"message", "stop_reason"}]} of the larger models
+func parseMistralOutput(outputBody any) OperationUsage {
+	return OperationUsage{
+		InputTokenCount:  int(jsonNumber(outputBody, "usage.prompt_tokens")),
+		OutputTokenCount: int(jsonNumber(outputBody, "usage.completion_tokens")),
+		StopReason:       jsonString(outputBody, "outputs.0.stop_reason", "choices.0.stop_reason", "choices.0.finish_reason"),
+		OutputCount:      jsonArrayLength(outputBody, "outputs", "choices"),
+	}
+}
+
+// parseCohereOutput parses {"generations": [{"text", "finish_reason"}]} of Command, or
+// the chat response {"text", "finish_reason", "meta": {"billed_units"}} of Command R
+func parseCohereOutput(outputBody any) OperationUsage {
+	usage := OperationUsage{
+		InputTokenCount:  int(jsonNumber(outputBody, "meta.billed_units.input_tokens")),
+		OutputTokenCount: int(jsonNumber(outputBody, "meta.billed_units.output_tokens")),
+		StopReason:       jsonString(outputBody, "generations.0.finish_reason", "finish_reason"),
+		OutputCount:      jsonArrayLength(outputBody, "generations"),
+	}
+	if usage.OutputCount == 0 && jsonValue(outputBody, "text") != nil {
+		usage.OutputCount = 1
+	}
+	return usage
+}
+
+// parseAI21Output parses {"completions": [{"data", "finishReason": {"reason"}}]} of
+// Jurassic-2, or the chat completion {"choices": [{"finish_reason"}], "usage"} of Jamba
+func parseAI21Output(outputBody any) OperationUsage {
+	return OperationUsage{
+		InputTokenCount:  int(jsonNumber(outputBody, "usage.prompt_tokens")),
+		OutputTokenCount: int(jsonNumber(outputBody, "usage.completion_tokens")),
+		StopReason:       jsonString(outputBody, "completions.0.finishReason.reason", "choices.0.finish_reason"),
+		OutputCount:      jsonArrayLength(outputBody, "completions", "choices"),
+	}
+}
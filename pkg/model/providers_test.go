@@ -0,0 +1,72 @@
+package model
+
+import (
+	"encoding/json"
+	"flag"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+var update = flag.Bool("update", false, "rewrite the golden files of the provider fixtures")
+
+// TestProviderParsersGolden parses the output body of each provider fixture in
+// test_data/providers and compares the usage with its golden file. Run
+// `go test ./pkg/model -run Golden -update` to regenerate the golden files.
+func TestProviderParsersGolden(t *testing.T) {
+	fixtures, err := filepath.Glob("test_data/providers/*.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, fixture := range fixtures {
+		if strings.HasSuffix(fixture, ".golden.json") {
+			continue
+		}
+
+		data, err := os.ReadFile(fixture)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var response struct {
+			Operation      string `json:"operation"`
+			ModelID        string `json:"modelId"`
+			OutputBodyJSON any    `json:"outputBodyJson"`
+		}
+		if err := json.Unmarshal(data, &response); err != nil {
+			t.Fatal(err)
+		}
+
+		usage := parseOperationUsage(response.Operation, response.ModelID, response.OutputBodyJSON)
+		generated, err := json.MarshalIndent(usage, "", "  ")
+		if err != nil {
+			t.Fatal(err)
+		}
+		generated = append(generated, '\n')
+
+		golden := strings.TrimSuffix(fixture, ".json") + ".golden.json"
+		if *update {
+			if err := os.WriteFile(golden, generated, 0644); err != nil {
+				t.Fatal(err)
+			}
+			continue
+		}
+
+		wanted, err := os.ReadFile(golden)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(generated) != string(wanted) {
+			t.Errorf("%s: got %s, wanted %s", fixture, generated, wanted)
+		}
+	}
+}
+
+func TestProviderParserUnknownModel(t *testing.T) {
+	usage := providerParser("example.unknown-model-v1")(map[string]any{"usage": map[string]any{"prompt_tokens": 3.0, "completion_tokens": 5.0}})
+	if usage != (OperationUsage{InputTokenCount: 3, OutputTokenCount: 5}) {
+		t.Errorf("got %+v, wanted the common token counts", usage)
+	}
+}
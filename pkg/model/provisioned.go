@@ -0,0 +1,235 @@
+package model
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/arn"
+)
+
+// Provisioned Throughput commitment terms
+const (
+	CommitmentNone     = "none"
+	CommitmentOneMonth = "1-month"
+	CommitmentSixMonth = "6-month"
+)
+
+// ProvisionedThroughputPrice is the hourly price of one model unit for a commitment term
+type ProvisionedThroughputPrice struct {
+	Commitment           string  `json:"commitment"`
+	CostPerModelUnitHour float64 `json:"cost_per_model_unit_hour"`
+}
+
+// ProvisionedModel maps a provisioned model ARN to the base model it serves, with
+// the purchased model units and commitment term
+type ProvisionedModel struct {
+	ModelID    string `json:"model_id"`
+	ModelUnits int    `json:"model_units"`
+	Commitment string `json:"commitment"`
+}
+
+// NewProvisionedModels parses provisioned models keyed by provisioned model ARN
+func NewProvisionedModels(provisionedModelDetails []byte) (map[string]ProvisionedModel, error) {
+	var provisionedModels map[string]ProvisionedModel
+	if err := json.Unmarshal(provisionedModelDetails, &provisionedModels); err != nil {
+		return nil, err
+	}
+
+	for provisionedModelArn, provisionedModel := range provisionedModels {
+		if !isProvisionedModelArn(provisionedModelArn) {
+			return nil, fmt.Errorf("%q is not a provisioned model ARN", provisionedModelArn)
+		}
+		if provisionedModel.ModelID == "" || provisionedModel.ModelUnits < 1 {
+			return nil, fmt.Errorf("provisioned model %s needs a model ID and at least one model unit", provisionedModelArn)
+		}
+		if !validCommitment(provisionedModel.Commitment) {
+			return nil, fmt.Errorf("unsupported commitment %q for provisioned model %s", provisionedModel.Commitment, provisionedModelArn)
+		}
+	}
+
+	return provisionedModels, nil
+}
+
+func validCommitment(commitment string) bool {
+	switch commitment {
+	case CommitmentNone, CommitmentOneMonth, CommitmentSixMonth:
+		return true
+	default:
+		return false
+	}
+}
+
+func isProvisionedModelArn(modelID string) bool {
+	modelIDARN, err := arn.Parse(modelID)
+	return err == nil && strings.HasPrefix(modelIDARN.Resource, "provisioned-model/")
+}
+
+// EstimateProvisionedThroughputHourlyCost returns the cost of an hour of the
+// provisioned model's units at the base model's price effective at that hour
+func (m *CostEstimator) EstimateProvisionedThroughputHourlyCost(provisionedModel ProvisionedModel, region string, hour time.Time) (float64, bool) {
+	_, costByRegion := m.cost(&InvocationLogMetadata{ModelID: provisionedModel.ModelID, Region: region, Timestamp: hour})
+	if costByRegion == nil {
+		return 0, false
+	}
+
+	for _, price := range costByRegion.ProvisionedThroughput {
+		if price.Commitment == provisionedModel.Commitment {
+			return price.CostPerModelUnitHour * float64(provisionedModel.ModelUnits), true
+		}
+	}
+	return 0, false
+}
+
+// ProvisionedThroughputRollup amortizes an hour of a provisioned model's cost across
+// the invocations served in that hour, grouped by identity tags
+type ProvisionedThroughputRollup struct {
+	Hour                 time.Time                         `json:"hour"`
+	AccountID            string                            `json:"accountId"`
+	Region               string                            `json:"region"`
+	ProvisionedModelArn  string                            `json:"provisionedModelArn"`
+	ModelID              string                            `json:"modelId"`
+	ModelUnits           int                               `json:"modelUnits"`
+	Commitment           string                            `json:"commitment"`
+	HourlyCostUSD        float64                           `json:"hourlyCostUSD"`
+	Invocations          int                               `json:"invocations"`
+	CostPerInvocationUSD float64                           `json:"costPerInvocationUSD"`
+	UnallocatedCostUSD   float64                           `json:"unallocatedCostUSD"`
+	Allocations          []ProvisionedThroughputAllocation `json:"allocations"`
+}
+
+// ProvisionedThroughputAllocation is the share of an hour of provisioned throughput
+// cost for the invocations with the same identity tags
+type ProvisionedThroughputAllocation struct {
+	IdentityTags []IdentityTag `json:"identityTags"`
+	Invocations  int           `json:"invocations"`
+	CostUSD      float64       `json:"costUSD"`
+}
+
+// RollupProvisionedThroughput amortizes the hour's cost of every provisioned model in
+// the account and region across the given invocations of that hour
+func (m *MetadataGenerator) RollupProvisionedThroughput(accountID, region string, hour time.Time, metadata []InvocationLogMetadata) []ProvisionedThroughputRollup {
+	aggregator := m.NewProvisionedThroughputAggregator(accountID, region, hour)
+	for i := range metadata {
+		aggregator.Add(&metadata[i])
+	}
+	return aggregator.Rollups()
+}
+
+// ProvisionedThroughputAggregator counts the invocations of provisioned models in an
+// hour by identity tags as they are added, so the hour is never held in memory
+type ProvisionedThroughputAggregator struct {
+	generator   *MetadataGenerator
+	accountID   string
+	region      string
+	hour        time.Time
+	allocations map[string]map[string]*ProvisionedThroughputAllocation
+}
+
+// NewProvisionedThroughputAggregator creates an aggregator for the invocations of an
+// hour in the account and region
+func (m *MetadataGenerator) NewProvisionedThroughputAggregator(accountID, region string, hour time.Time) *ProvisionedThroughputAggregator {
+	return &ProvisionedThroughputAggregator{
+		generator:   m,
+		accountID:   accountID,
+		region:      region,
+		hour:        hour,
+		allocations: make(map[string]map[string]*ProvisionedThroughputAllocation),
+	}
+}
+
+// Add counts the invocation when it was served by a provisioned model
+func (a *ProvisionedThroughputAggregator) Add(invocation *InvocationLogMetadata) {
+	if invocation.ProvisionedModelArn == "" {
+		return
+	}
+
+	allocations, ok := a.allocations[invocation.ProvisionedModelArn]
+	if !ok {
+		allocations = make(map[string]*ProvisionedThroughputAllocation)
+		a.allocations[invocation.ProvisionedModelArn] = allocations
+	}
+
+	key := identityTagsKey(invocation.IdentityTags)
+	allocation, ok := allocations[key]
+	if !ok {
+		allocation = &ProvisionedThroughputAllocation{IdentityTags: invocation.IdentityTags}
+		allocations[key] = allocation
+	}
+	allocation.Invocations++
+}
+
+// Rollups amortizes the hour's cost of every provisioned model in the account and
+// region across the added invocations. The cost of an hour without invocations is
+// reported as unallocated.
+func (a *ProvisionedThroughputAggregator) Rollups() []ProvisionedThroughputRollup {
+	var rollups []ProvisionedThroughputRollup
+	for provisionedModelArn, provisionedModel := range a.generator.provisionedModels {
+		modelIDARN, _ := arn.Parse(provisionedModelArn)
+		if modelIDARN.AccountID != a.accountID || modelIDARN.Region != a.region {
+			continue
+		}
+
+		hourlyCost, ok := a.generator.modelCost.EstimateProvisionedThroughputHourlyCost(provisionedModel, a.region, a.hour)
+		if !ok {
+			continue
+		}
+
+		rollup := ProvisionedThroughputRollup{
+			Hour:                a.hour,
+			AccountID:           a.accountID,
+			Region:              a.region,
+			ProvisionedModelArn: provisionedModelArn,
+			ModelID:             provisionedModel.ModelID,
+			ModelUnits:          provisionedModel.ModelUnits,
+			Commitment:          provisionedModel.Commitment,
+			HourlyCostUSD:       hourlyCost,
+			Allocations:         []ProvisionedThroughputAllocation{},
+		}
+
+		allocations := a.allocations[provisionedModelArn]
+		for _, allocation := range allocations {
+			rollup.Invocations += allocation.Invocations
+		}
+		if rollup.Invocations == 0 {
+			rollup.UnallocatedCostUSD = hourlyCost
+			rollups = append(rollups, rollup)
+			continue
+		}
+		rollup.CostPerInvocationUSD = hourlyCost / float64(rollup.Invocations)
+
+		keys := make([]string, 0, len(allocations))
+		for key := range allocations {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			allocation := *allocations[key]
+			allocation.CostUSD = rollup.CostPerInvocationUSD * float64(allocation.Invocations)
+			rollup.Allocations = append(rollup.Allocations, allocation)
+		}
+		rollups = append(rollups, rollup)
+	}
+
+	sort.Slice(rollups, func(i, j int) bool {
+		return rollups[i].ProvisionedModelArn < rollups[j].ProvisionedModelArn
+	})
+	return rollups
+}
+
+// ProvisionedThroughputEnabled reports whether any provisioned models are configured
+func (m *MetadataGenerator) ProvisionedThroughputEnabled() bool {
+	return len(m.provisionedModels) > 0
+}
+
+// identityTagsKey identifies a set of identity tags regardless of their order
+func identityTagsKey(identityTags []IdentityTag) string {
+	tags := make([]string, len(identityTags))
+	for i, identityTag := range identityTags {
+		tags[i] = identityTag.Key + "=" + identityTag.Value
+	}
+	sort.Strings(tags)
+	return strings.Join(tags, "\x00")
+}
@@ -0,0 +1,120 @@
+package model
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+const testProvisionedModelArn = "arn:aws:bedrock:us-east-1:893487256304:provisioned-model/a1b2c3d4e5f6"
+
+func newTestProvisionedModels(t *testing.T) map[string]ProvisionedModel {
+	t.Helper()
+
+	provisionedModels, err := NewProvisionedModels([]byte(fmt.Sprintf(`{
+	  %q: {"model_id": "anthropic.claude-instant-v1", "model_units": 2, "commitment": "1-month"},
+	  "arn:aws:bedrock:us-east-1:893487256304:provisioned-model/idle": {"model_id": "amazon.titan-text-lite-v1", "model_units": 1, "commitment": "none"},
+	  "arn:aws:bedrock:us-west-2:893487256304:provisioned-model/other-region": {"model_id": "amazon.titan-text-lite-v1", "model_units": 1, "commitment": "none"}
+	}`, testProvisionedModelArn)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return provisionedModels
+}
+
+func TestMetadataGenerator_GenerateModelInvocationLogMetadataProvisioned(t *testing.T) {
+	modelMetaDataGenerator := newTestGenerator(t, GeneratorOptions{ProvisionedModels: newTestProvisionedModels(t)})
+
+	invocationLog := &InvocationLog{
+		Region:  "us-east-1",
+		ModelID: testProvisionedModelArn,
+	}
+	invocationLog.Input.InputTokenCount = 1000
+	invocationLog.Output.OutputTokenCount = 1000
+
+	metadata, err := modelMetaDataGenerator.GenerateModelInvocationLogMetadata(invocationLog)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if metadata.ModelID != "anthropic.claude-instant-v1" {
+		t.Errorf("got %q, wanted %q", metadata.ModelID, "anthropic.claude-instant-v1")
+	}
+
+	if metadata.ProvisionedModelArn != testProvisionedModelArn {
+		t.Errorf("got %q, wanted %q", metadata.ProvisionedModelArn, testProvisionedModelArn)
+	}
+
+	if metadata.ModelName != "Claude Instant" {
+		t.Errorf("got %q, wanted %q", metadata.ModelName, "Claude Instant")
+	}
+
+	// Provisioned throughput is not billed by token
+	if metadata.TotalCostUSD() != 0 {
+		t.Errorf("got %f, wanted %f", metadata.TotalCostUSD(), 0.0)
+	}
+}
+
+func TestMetadataGenerator_RollupProvisionedThroughput(t *testing.T) {
+	modelMetaDataGenerator := newTestGenerator(t, GeneratorOptions{ProvisionedModels: newTestProvisionedModels(t)})
+	hour := time.Date(2024, 3, 5, 20, 0, 0, 0, time.UTC)
+
+	search := []IdentityTag{{Key: "team", Value: "search"}}
+	ads := []IdentityTag{{Key: "team", Value: "ads"}}
+	metadata := []InvocationLogMetadata{
+		{ProvisionedModelArn: testProvisionedModelArn, IdentityTags: search},
+		{ProvisionedModelArn: testProvisionedModelArn, IdentityTags: search},
+		{ProvisionedModelArn: testProvisionedModelArn, IdentityTags: ads},
+		{ProvisionedModelArn: testProvisionedModelArn},
+		{ModelID: "anthropic.claude-instant-v1"},
+	}
+
+	rollups := modelMetaDataGenerator.RollupProvisionedThroughput("893487256304", "us-east-1", hour, metadata)
+	if len(rollups) != 2 {
+		t.Fatalf("got %d rollups, wanted %d", len(rollups), 2)
+	}
+
+	// Two model units of Claude Instant with a one month commitment
+	rollup := rollups[0]
+	if rollup.ProvisionedModelArn != testProvisionedModelArn {
+		t.Fatalf("got %q, wanted %q", rollup.ProvisionedModelArn, testProvisionedModelArn)
+	}
+	if fmt.Sprintf("%.2f", rollup.HourlyCostUSD) != "79.20" {
+		t.Errorf("got %.2f, wanted %s", rollup.HourlyCostUSD, "79.20")
+	}
+	if rollup.Invocations != 4 {
+		t.Errorf("got %d, wanted %d", rollup.Invocations, 4)
+	}
+
+	wanted := map[string]string{"": "19.80", "team=ads": "19.80", "team=search": "39.60"}
+	if len(rollup.Allocations) != len(wanted) {
+		t.Fatalf("got %d allocations, wanted %d", len(rollup.Allocations), len(wanted))
+	}
+	for _, allocation := range rollup.Allocations {
+		key := identityTagsKey(allocation.IdentityTags)
+		if got := fmt.Sprintf("%.2f", allocation.CostUSD); got != wanted[key] {
+			t.Errorf("%q: got %s, wanted %s", key, got, wanted[key])
+		}
+	}
+
+	// An idle provisioned model's hour is still paid for
+	idle := rollups[1]
+	if idle.Invocations != 0 || fmt.Sprintf("%.2f", idle.UnallocatedCostUSD) != "7.10" {
+		t.Errorf("got %d invocations and %.2f unallocated, wanted 0 and 7.10", idle.Invocations, idle.UnallocatedCostUSD)
+	}
+}
+
+func TestNewProvisionedModelsInvalid(t *testing.T) {
+	tests := map[string]string{
+		"not an arn":  `{"claude": {"model_id": "anthropic.claude-v2", "model_units": 1, "commitment": "none"}}`,
+		"no units":    `{"arn:aws:bedrock:us-east-1:893487256304:provisioned-model/a": {"model_id": "anthropic.claude-v2", "commitment": "none"}}`,
+		"commitment":  `{"arn:aws:bedrock:us-east-1:893487256304:provisioned-model/a": {"model_id": "anthropic.claude-v2", "model_units": 1, "commitment": "1-year"}}`,
+		"no model id": `{"arn:aws:bedrock:us-east-1:893487256304:provisioned-model/a": {"model_units": 1, "commitment": "none"}}`,
+	}
+
+	for name, provisionedModels := range tests {
+		if _, err := NewProvisionedModels([]byte(provisionedModels)); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
@@ -0,0 +1,224 @@
+package model
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// Redaction actions
+const (
+	RedactionKeep     = "keep"
+	RedactionDrop     = "drop"
+	RedactionHash     = "hash"
+	RedactionTruncate = "truncate"
+	RedactionLength   = "length"
+)
+
+// RedactionRule redacts the value at a dotted path of the invocation log, such as
+// input.inputBodyJson.system or identity.arn. Array elements are addressed by index,
+// and a * segment matches every element of an array or value of an object.
+type RedactionRule struct {
+	Path      string `json:"path"`
+	Action    string `json:"action"`
+	MaxLength int    `json:"max_length,omitempty"`
+}
+
+// RedactionPolicy decides what of an invocation log, beyond its metadata, is retained
+// and how. Values without a rule are dropped, as are values of drop rules. Values of
+// identity.arn, accountId and requestId are redacted in place; the values of other
+// paths are retained as RetainedFields. Redacting accountId only redacts that field:
+// the account remains in the account partition, rollups and ARNs such as identity.arn.
+type RedactionPolicy struct {
+	Salt  string          `json:"salt"`
+	Rules []RedactionRule `json:"rules"`
+}
+
+// RetainedField is a redacted value of an invocation log retained by the redaction
+// policy
+type RetainedField struct {
+	Path  string `json:"path" parquet:"path"`
+	Value string `json:"value" parquet:"value"`
+}
+
+// NewRedactionPolicy parses a redaction policy. Hash rules need a salt; a non-empty
+// salt replaces the salt of the policy, so it can be kept out of the policy file.
+func NewRedactionPolicy(redactionPolicyDetails []byte, salt string) (*RedactionPolicy, error) {
+	var policy RedactionPolicy
+	if err := json.Unmarshal(redactionPolicyDetails, &policy); err != nil {
+		return nil, err
+	}
+	if salt != "" {
+		policy.Salt = salt
+	}
+
+	paths := make(map[string]bool)
+	for _, rule := range policy.Rules {
+		if rule.Path == "" {
+			return nil, errors.New("redaction rule needs a path")
+		}
+		if paths[rule.Path] {
+			return nil, fmt.Errorf("duplicate redaction rule for %s", rule.Path)
+		}
+		paths[rule.Path] = true
+
+		switch rule.Action {
+		case RedactionKeep, RedactionDrop, RedactionLength:
+		case RedactionHash:
+			if policy.Salt == "" {
+				return nil, fmt.Errorf("hash rule for %s needs a salt", rule.Path)
+			}
+		case RedactionTruncate:
+			if rule.MaxLength < 1 {
+				return nil, fmt.Errorf("truncate rule for %s needs a max_length of at least 1", rule.Path)
+			}
+		default:
+			return nil, fmt.Errorf("unsupported redaction action %q for %s", rule.Action, rule.Path)
+		}
+	}
+
+	return &policy, nil
+}
+
+// metadataRedactionFields are the values of the metadata that rules redact in place
+func metadataRedactionFields(metadata *InvocationLogMetadata) map[string]*string {
+	return map[string]*string{
+		"identity.arn": &metadata.Identity.Arn,
+		"accountId":    &metadata.AccountID,
+		"requestId":    &metadata.RequestID,
+	}
+}
+
+// apply redacts the metadata in place and retains the values of the invocation log
+// that the rules keep
+func (p *RedactionPolicy) apply(invocationLog *InvocationLog, metadata *InvocationLogMetadata) error {
+	var logJSON any
+	inPlace := metadataRedactionFields(metadata)
+	for _, rule := range p.Rules {
+		if field, ok := inPlace[rule.Path]; ok {
+			if value, ok := p.redact(rule, *field); ok {
+				*field = value
+			} else {
+				*field = ""
+			}
+			continue
+		}
+		if rule.Action == RedactionDrop {
+			continue
+		}
+
+		// The log is only converted to JSON values when there are values to retain
+		if logJSON == nil {
+			logJSONBytes, err := json.Marshal(invocationLog)
+			if err != nil {
+				return fmt.Errorf("unable to redact invocation log %s, %v", invocationLog.RequestID, err)
+			}
+			if err := json.Unmarshal(logJSONBytes, &logJSON); err != nil {
+				return fmt.Errorf("unable to redact invocation log %s, %v", invocationLog.RequestID, err)
+			}
+		}
+
+		for _, match := range jsonPathValues(logJSON, "", strings.Split(rule.Path, "."), nil) {
+			if redacted, ok := p.redact(rule, match.value); ok {
+				metadata.RetainedFields = append(metadata.RetainedFields, RetainedField{Path: match.path, Value: redacted})
+			}
+		}
+	}
+	return nil
+}
+
+type jsonPathValue struct {
+	path  string
+	value any
+}
+
+// jsonPathValues appends the values of body at the keys of a path, with the path of
+// each value where * segments are replaced by the index or key they matched
+func jsonPathValues(body any, path string, keys []string, values []jsonPathValue) []jsonPathValue {
+	if body == nil {
+		return values
+	}
+	if len(keys) == 0 {
+		return append(values, jsonPathValue{path: path, value: body})
+	}
+
+	key, keys := keys[0], keys[1:]
+	if key != "*" {
+		return jsonPathValues(jsonValue(body, key), joinPath(path, key), keys, values)
+	}
+	switch value := body.(type) {
+	case []any:
+		for index, element := range value {
+			values = jsonPathValues(element, joinPath(path, strconv.Itoa(index)), keys, values)
+		}
+	case map[string]any:
+		objectKeys := make([]string, 0, len(value))
+		for objectKey := range value {
+			objectKeys = append(objectKeys, objectKey)
+		}
+		sort.Strings(objectKeys)
+		for _, objectKey := range objectKeys {
+			values = jsonPathValues(value[objectKey], joinPath(path, objectKey), keys, values)
+		}
+	}
+	return values
+}
+
+func joinPath(path, key string) string {
+	if path == "" {
+		return key
+	}
+	return path + "." + key
+}
+
+// redact returns the value as the rule retains it, or false when it is dropped.
+// Values other than strings are redacted as their JSON encoding, except that the
+// length of an array is its number of elements.
+func (p *RedactionPolicy) redact(rule RedactionRule, value any) (string, bool) {
+	if rule.Action == RedactionDrop {
+		return "", false
+	}
+	if elements, ok := value.([]any); ok && rule.Action == RedactionLength {
+		return strconv.Itoa(len(elements)), true
+	}
+
+	text, ok := value.(string)
+	if !ok {
+		encoded, err := json.Marshal(value)
+		if err != nil {
+			return "", false
+		}
+		text = string(encoded)
+	}
+
+	switch rule.Action {
+	case RedactionHash:
+		mac := hmac.New(sha256.New, []byte(p.Salt))
+		mac.Write([]byte(text))
+		return hex.EncodeToString(mac.Sum(nil)), true
+	case RedactionTruncate:
+		return truncateRunes(text, rule.MaxLength), true
+	case RedactionLength:
+		return strconv.Itoa(utf8.RuneCountInString(text)), true
+	default:
+		return text, true
+	}
+}
+
+func truncateRunes(text string, maxLength int) string {
+	runes := 0
+	for i := range text {
+		if runes == maxLength {
+			return text[:i]
+		}
+		runes++
+	}
+	return text
+}
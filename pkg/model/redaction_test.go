@@ -0,0 +1,148 @@
+package model
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/parquet-go/parquet-go"
+)
+
+const testRedactionPolicy = `{
+  "rules": [
+    {"path": "identity.arn", "action": "hash"},
+    {"path": "input.inputBodyJson.messages.0.content.0.text", "action": "hash"},
+    {"path": "input.inputBodyJson.messages", "action": "length"},
+    {"path": "output.outputBodyJson.output.message.content.0.text", "action": "truncate", "max_length": 5},
+    {"path": "input.inputBodyJson.inferenceConfig.maxTokens", "action": "keep"},
+    {"path": "output.outputBodyJson.stopReason", "action": "drop"},
+    {"path": "input.inputBodyJson.system", "action": "keep"}
+  ]
+}`
+
+// testPrompt and testCompletion are the text of test_data/input_converse.json
+const (
+	testPrompt     = "Write a haiku about the ocean."
+	testCompletion = "Waves crash on the shore"
+)
+
+func TestNewRedactionPolicy(t *testing.T) {
+	invalid := map[string]string{
+		"no salt":       `{"rules": [{"path": "identity.arn", "action": "hash"}]}`,
+		"no path":       `{"rules": [{"action": "keep"}]}`,
+		"no max length": `{"rules": [{"path": "input.inputBodyJson.prompt", "action": "truncate"}]}`,
+		"unknown":       `{"rules": [{"path": "input.inputBodyJson.prompt", "action": "encrypt"}]}`,
+		"duplicate":     `{"rules": [{"path": "requestId", "action": "keep"}, {"path": "requestId", "action": "drop"}]}`,
+	}
+	for name, policy := range invalid {
+		if _, err := NewRedactionPolicy([]byte(policy), ""); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+
+	policy, err := NewRedactionPolicy([]byte(`{"salt": "file", "rules": [{"path": "identity.arn", "action": "hash"}]}`), "environment")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if policy.Salt != "environment" {
+		t.Errorf("got %q, wanted %q", policy.Salt, "environment")
+	}
+}
+
+func TestMetadataGenerator_GenerateModelInvocationLogMetadataRedacted(t *testing.T) {
+	redaction, err := NewRedactionPolicy([]byte(testRedactionPolicy), "pepper")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	invocationLog := readInvocationLog(t, "test_data/input_converse.json")
+	metadata, err := newTestGenerator(t, GeneratorOptions{Redaction: redaction}, testSageMakerEntity).GenerateModelInvocationLogMetadata(invocationLog)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	hashRule := RedactionRule{Action: RedactionHash}
+	wantedArn, _ := redaction.redact(hashRule, invocationLog.Identity.Arn)
+	if metadata.Identity.Arn != wantedArn || len(wantedArn) != 64 {
+		t.Errorf("got %q, wanted %q", metadata.Identity.Arn, wantedArn)
+	}
+
+	wantedPromptHash, _ := redaction.redact(hashRule, testPrompt)
+	wanted := []RetainedField{
+		{Path: "input.inputBodyJson.messages.0.content.0.text", Value: wantedPromptHash},
+		{Path: "input.inputBodyJson.messages", Value: "1"},
+		{Path: "output.outputBodyJson.output.message.content.0.text", Value: "Waves"},
+		{Path: "input.inputBodyJson.inferenceConfig.maxTokens", Value: "512"},
+	}
+	if !reflect.DeepEqual(metadata.RetainedFields, wanted) {
+		t.Errorf("got %+v, wanted %+v", metadata.RetainedFields, wanted)
+	}
+
+	// The metadata is still generated from the unredacted log
+	if metadata.StopReason != StopReasonEndTurn || metadata.OutputTokenCount != 24 {
+		t.Errorf("got stop reason %q and %d output tokens, wanted %q and 24", metadata.StopReason, metadata.OutputTokenCount, StopReasonEndTurn)
+	}
+}
+
+func TestMetadataGenerator_GenerateModelInvocationLogMetadataRedactedWildcard(t *testing.T) {
+	redaction, err := NewRedactionPolicy([]byte(`{"rules": [
+	  {"path": "input.inputBodyJson.messages.*.content.*.text", "action": "length"},
+	  {"path": "input.inputBodyJson.inferenceConfig.*", "action": "keep"},
+	  {"path": "input.inputBodyJson.system.*.text", "action": "keep"}
+	]}`), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	invocationLog := readInvocationLog(t, "test_data/input_converse.json")
+	metadata, err := newTestGenerator(t, GeneratorOptions{Redaction: redaction}, testSageMakerEntity).GenerateModelInvocationLogMetadata(invocationLog)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	wanted := []RetainedField{
+		{Path: "input.inputBodyJson.messages.0.content.0.text", Value: "30"},
+		{Path: "input.inputBodyJson.inferenceConfig.maxTokens", Value: "512"},
+		{Path: "input.inputBodyJson.inferenceConfig.temperature", Value: "0.5"},
+	}
+	if !reflect.DeepEqual(metadata.RetainedFields, wanted) {
+		t.Errorf("got %+v, wanted %+v", metadata.RetainedFields, wanted)
+	}
+}
+
+func TestMetadataGenerator_GenerateModelInvocationLogMetadataNoPromptText(t *testing.T) {
+	redaction, err := NewRedactionPolicy([]byte(testRedactionPolicy), "pepper")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for name, modelMetaDataGenerator := range map[string]*MetadataGenerator{
+		"default": newTestGenerator(t, GeneratorOptions{Redaction: nil}, testSageMakerEntity),
+		"policy":  newTestGenerator(t, GeneratorOptions{Redaction: redaction}, testSageMakerEntity),
+	} {
+		for _, logName := range []string{"test_data/input_converse.json", "test_data/input_converse_stream.json"} {
+			metadata, err := modelMetaDataGenerator.GenerateModelInvocationLogMetadata(readInvocationLog(t, logName))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			encoded, err := json.Marshal(metadata)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var parquetEncoded strings.Builder
+			if err := parquet.Write(&parquetEncoded, []InvocationLogMetadata{*metadata}); err != nil {
+				t.Fatal(err)
+			}
+
+			for format, output := range map[string]string{"json": string(encoded), "parquet": parquetEncoded.String()} {
+				for _, text := range []string{testPrompt, "about the ocean", testCompletion, "Salty breeze"} {
+					if strings.Contains(output, text) {
+						t.Errorf("%s %s %s: found %q in the metadata", name, logName, format, text)
+					}
+				}
+			}
+		}
+	}
+}
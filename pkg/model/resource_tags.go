@@ -0,0 +1,87 @@
+package model
+
+import (
+	"fmt"
+	"log"
+	"sync"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/bedrock"
+	"github.com/aws/aws-sdk-go/service/bedrock/bedrockiface"
+)
+
+// ResourceTag is a tag of the Bedrock resource invoked, such as an application
+// inference profile
+type ResourceTag struct {
+	Key   string `json:"key" parquet:"key"`
+	Value string `json:"value" parquet:"value"`
+}
+
+// ResourceTagsResolver looks up the tags of Bedrock resources by ARN
+type ResourceTagsResolver interface {
+	GetResourceTags(resourceArn string) ([]ResourceTag, error)
+}
+
+// ResourceTagsBuilder gets the tags of Bedrock resources with the Bedrock API of their
+// region, and caches them for the run. A resource whose tags can't be listed is
+// logged once and has no tags.
+type ResourceTagsBuilder struct {
+	newClient func(region string) bedrockiface.BedrockAPI
+
+	mu        sync.Mutex
+	clients   map[string]bedrockiface.BedrockAPI
+	tagsCache onceCache[[]ResourceTag]
+}
+
+func NewResourceTagsBuilder(sess *session.Session) *ResourceTagsBuilder {
+	return &ResourceTagsBuilder{
+		newClient: func(region string) bedrockiface.BedrockAPI {
+			return bedrock.New(sess, aws.NewConfig().WithRegion(region))
+		},
+		clients: make(map[string]bedrockiface.BedrockAPI),
+	}
+}
+
+func (r *ResourceTagsBuilder) GetResourceTags(resourceArn string) ([]ResourceTag, error) {
+	return r.tagsCache.get(resourceArn, func() ([]ResourceTag, error) {
+		tags, err := r.listTags(resourceArn)
+		if err != nil {
+			log.Printf("unable to get tags for %s, %v\n", resourceArn, err)
+			return []ResourceTag{}, nil
+		}
+		return tags, nil
+	})
+}
+
+func (r *ResourceTagsBuilder) listTags(resourceArn string) ([]ResourceTag, error) {
+	region, err := arnRegion(resourceArn)
+	if err != nil {
+		return nil, err
+	}
+
+	result, err := r.client(region).ListTagsForResource(&bedrock.ListTagsForResourceInput{
+		ResourceARN: aws.String(resourceArn),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("unable to list tags of %s, %v", resourceArn, err)
+	}
+
+	tags := make([]ResourceTag, 0, len(result.Tags))
+	for _, tag := range result.Tags {
+		tags = append(tags, ResourceTag{Key: aws.StringValue(tag.Key), Value: aws.StringValue(tag.Value)})
+	}
+	return tags, nil
+}
+
+func (r *ResourceTagsBuilder) client(region string) bedrockiface.BedrockAPI {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	client, ok := r.clients[region]
+	if !ok {
+		client = r.newClient(region)
+		r.clients[region] = client
+	}
+	return client
+}
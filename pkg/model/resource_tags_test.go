@@ -0,0 +1,89 @@
+package model
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/bedrock"
+	"github.com/aws/aws-sdk-go/service/bedrock/bedrockiface"
+)
+
+const testApplicationProfileArn = "arn:aws:bedrock:us-east-1:893487256304:application-inference-profile/a1b2c3d4e5f6"
+
+type fakeBedrockTagsClient struct {
+	bedrockiface.BedrockAPI
+	tags  map[string][]*bedrock.Tag
+	calls int
+}
+
+func (f *fakeBedrockTagsClient) ListTagsForResource(input *bedrock.ListTagsForResourceInput) (*bedrock.ListTagsForResourceOutput, error) {
+	f.calls++
+	tags, ok := f.tags[aws.StringValue(input.ResourceARN)]
+	if !ok {
+		return nil, errors.New("resource not found")
+	}
+	return &bedrock.ListTagsForResourceOutput{Tags: tags}, nil
+}
+
+func newTestResourceTagsBuilder(client *fakeBedrockTagsClient) *ResourceTagsBuilder {
+	return &ResourceTagsBuilder{
+		newClient: func(region string) bedrockiface.BedrockAPI { return client },
+		clients:   make(map[string]bedrockiface.BedrockAPI),
+	}
+}
+
+func TestResourceTagsBuilder_GetResourceTags(t *testing.T) {
+	client := &fakeBedrockTagsClient{tags: map[string][]*bedrock.Tag{
+		testApplicationProfileArn: {{Key: aws.String("team"), Value: aws.String("search")}},
+	}}
+	resourceTags := newTestResourceTagsBuilder(client)
+
+	for i := 0; i < 2; i++ {
+		tags, err := resourceTags.GetResourceTags(testApplicationProfileArn)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(tags) != 1 || tags[0] != (ResourceTag{Key: "team", Value: "search"}) {
+			t.Errorf("got %+v, wanted the tags of the profile", tags)
+		}
+	}
+
+	if client.calls != 1 {
+		t.Errorf("got %d calls, wanted the tags to be cached after %d", client.calls, 1)
+	}
+
+	// A profile whose tags can't be listed has no tags, and is not retried
+	for i := 0; i < 2; i++ {
+		tags, err := resourceTags.GetResourceTags("arn:aws:bedrock:us-east-1:893487256304:application-inference-profile/unknown")
+		if err != nil || len(tags) != 0 {
+			t.Errorf("got %+v, %v, wanted no tags", tags, err)
+		}
+	}
+	if client.calls != 2 {
+		t.Errorf("got %d calls, wanted the failure to be cached after %d", client.calls, 2)
+	}
+}
+
+func TestMetadataGenerator_GenerateModelInvocationLogMetadataResourceTags(t *testing.T) {
+	client := &fakeBedrockTagsClient{tags: map[string][]*bedrock.Tag{
+		testApplicationProfileArn: {{Key: aws.String("cost-center"), Value: aws.String("1234")}},
+	}}
+	modelMetaDataGenerator := newTestGenerator(t, GeneratorOptions{ResourceTags: newTestResourceTagsBuilder(client)})
+
+	metadata, err := modelMetaDataGenerator.GenerateModelInvocationLogMetadata(&InvocationLog{Region: "us-east-1", ModelID: testApplicationProfileArn})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(metadata.ResourceTags) != 1 || metadata.ResourceTags[0] != (ResourceTag{Key: "cost-center", Value: "1234"}) {
+		t.Errorf("got %+v, wanted the tags of the profile", metadata.ResourceTags)
+	}
+
+	metadata, err = modelMetaDataGenerator.GenerateModelInvocationLogMetadata(&InvocationLog{Region: "us-east-1", ModelID: "anthropic.claude-v2"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(metadata.ResourceTags) != 0 || client.calls != 1 {
+		t.Errorf("got %+v after %d calls, wanted no tags for a foundation model", metadata.ResourceTags, client.calls)
+	}
+}
@@ -7,24 +7,36 @@ type InvocationLog struct {
 	SchemaVersion string    `json:"schemaVersion"`
 	Timestamp     time.Time `json:"timestamp"`
 	AccountID     string    `json:"accountId"`
-	Identity      struct {
-		Arn string `json:"arn"`
-	} `json:"identity"`
-	Region    string `json:"region"`
-	RequestID string `json:"requestId"`
-	Operation string `json:"operation"`
-	ModelID   string `json:"modelId"`
-	Input     struct {
-		InputContentType string `json:"inputContentType"`
-		InputTokenCount  int    `json:"inputTokenCount"`
+	Identity      Identity  `json:"identity"`
+	Region        string    `json:"region"`
+	RequestID     string    `json:"requestId"`
+	Operation     string    `json:"operation"`
+	ModelID       string    `json:"modelId"`
+	Input         struct {
+		InputContentType          string `json:"inputContentType"`
+		InputTokenCount           int    `json:"inputTokenCount"`
+		CacheReadInputTokenCount  int    `json:"cacheReadInputTokenCount"`
+		CacheWriteInputTokenCount int    `json:"cacheWriteInputTokenCount"`
+		InputBodyJSON             any    `json:"inputBodyJson"`
 	} `json:"input"`
 	Output struct {
 		OutputContentType string `json:"outputContentType"`
 		OutputTokenCount  int    `json:"outputTokenCount"`
 		OutputBodyJSON    any    `json:"outputBodyJson"`
 	} `json:"output"`
+	// PricingMode is set for records that are not billed on demand, such as batch
+	// inference output records
+	PricingMode string `json:"-"`
 }
 
+// Pricing modes of invocations
+const (
+	PricingModeOnDemand    = "on_demand"
+	PricingModeBatch       = "batch"
+	PricingModeProvisioned = "provisioned"
+)
+
+// Deprecated: usage and latency are read per operation into OperationUsage.
 type AmazonBedrockInvocationMetrics struct {
 	InputTokenCount   int `json:"inputTokenCount"`
 	OutputTokenCount  int `json:"outputTokenCount"`
@@ -32,6 +44,8 @@ type AmazonBedrockInvocationMetrics struct {
 	FirstByteLatency  int `json:"firstByteLatency"`
 }
 
+// Deprecated: output bodies differ by operation and model and are read into
+// OperationUsage.
 type InvocationLogOutputBodyJSON struct {
 	OutputText                     string                         `json:"outputText"`
 	Index                          int                            `json:"index"`
@@ -41,32 +55,65 @@ type InvocationLogOutputBodyJSON struct {
 	AmazonBedrockInvocationMetrics AmazonBedrockInvocationMetrics `json:"amazon-bedrock-invocationMetrics,omitempty"`
 }
 
+type Identity struct {
+	Arn string `json:"arn" parquet:"arn"`
+}
+
 type IdentityTag struct {
-	Key   string `json:"key"`
-	Value string `json:"value"`
+	Key   string `json:"key" parquet:"key"`
+	Value string `json:"value" parquet:"value"`
 }
 
+// InvocationLogMetadata is written as JSON lines or as Parquet rows, so fields carry
+// both json and parquet tags with the same column names. Prompt cache read and write
+// tokens are counted and priced apart from the other input tokens; CacheSavingsUSD is
+// their cost at the input token price less their cost at the cache prices, and is
+// negative when cache writes were not reused.
 type InvocationLogMetadata struct {
-	Timestamp time.Time `json:"timestamp"`
-	AccountID string    `json:"accountId"`
-	Identity  struct {
-		Arn string `json:"arn"`
-	} `json:"identity"`
-	IdentityTags         []IdentityTag `json:"identityTags"`
-	Region               string        `json:"region"`
-	RequestID            string        `json:"requestId"`
-	Operation            string        `json:"operation"`
-	ModelID              string        `json:"modelId"`
-	ModelName            string        `json:"modelName"`
-	ModelProvider        string        `json:"modelProvider"`
-	InputContentType     string        `json:"inputContentType"`
-	OutputContentType    string        `json:"outputContentType"`
-	InputTokenCount      int           `json:"inputTokenCount"`
-	OutputTokenCount     int           `json:"outputTokenCount"`
-	InputTokenCostUSD    float64       `json:"inputTokenCostUSD"`
-	OutputTokenCostUSD   float64       `json:"outputTokenCostUSD"`
-	InvocationLatency    int           `json:"invocationLatency,omitempty"`
-	FirstByteLatency     int           `json:"firstByteLatency,omitempty"`
-	EnergyConsumptionkWh float64       `json:"energyConsumptionkWh,omitempty"`
-	CarbonEmissiongCO2e  float64       `json:"carbonEmissiongCO2e,omitempty"`
+	Timestamp                   time.Time        `json:"timestamp" parquet:"timestamp,timestamp(millisecond)"`
+	AccountID                   string           `json:"accountId" parquet:"accountId"`
+	Identity                    Identity         `json:"identity" parquet:"identity"`
+	IdentityTags                []IdentityTag    `json:"identityTags" parquet:"identityTags,list"`
+	ResourceTags                []ResourceTag    `json:"resourceTags" parquet:"resourceTags,list"`
+	Region                      string           `json:"region" parquet:"region"`
+	RequestID                   string           `json:"requestId" parquet:"requestId"`
+	Operation                   string           `json:"operation" parquet:"operation"`
+	ModelID                     string           `json:"modelId" parquet:"modelId"`
+	ModelName                   string           `json:"modelName" parquet:"modelName"`
+	ModelProvider               string           `json:"modelProvider" parquet:"modelProvider"`
+	ProvisionedModelArn         string           `json:"provisionedModelArn,omitempty" parquet:"provisionedModelArn,optional"`
+	PricingMode                 string           `json:"pricingMode" parquet:"pricingMode"`
+	InferenceProfileID          string           `json:"inferenceProfileId,omitempty" parquet:"inferenceProfileId,optional"`
+	InferenceProfileGeography   string           `json:"inferenceProfileGeography,omitempty" parquet:"inferenceProfileGeography,optional"`
+	InputContentType            string           `json:"inputContentType" parquet:"inputContentType"`
+	OutputContentType           string           `json:"outputContentType" parquet:"outputContentType"`
+	StopReason                  string           `json:"stopReason,omitempty" parquet:"stopReason,optional"`
+	Truncated                   bool             `json:"truncated" parquet:"truncated"`
+	OutputCount                 int              `json:"outputCount,omitempty" parquet:"outputCount,optional"`
+	InferenceParams             *InferenceParams `json:"inferenceParams,omitempty" parquet:"inferenceParams,optional"`
+	RetainedFields              []RetainedField  `json:"retainedFields,omitempty" parquet:"retainedFields,list"`
+	PromptFingerprint           string           `json:"promptFingerprint,omitempty" parquet:"promptFingerprint,optional"`
+	SystemPromptFingerprint     string           `json:"systemPromptFingerprint,omitempty" parquet:"systemPromptFingerprint,optional"`
+	CompletionFingerprint       string           `json:"completionFingerprint,omitempty" parquet:"completionFingerprint,optional"`
+	InputTokenCount             int              `json:"inputTokenCount" parquet:"inputTokenCount"`
+	OutputTokenCount            int              `json:"outputTokenCount" parquet:"outputTokenCount"`
+	CacheReadInputTokenCount    int              `json:"cacheReadInputTokenCount,omitempty" parquet:"cacheReadInputTokenCount,optional"`
+	CacheWriteInputTokenCount   int              `json:"cacheWriteInputTokenCount,omitempty" parquet:"cacheWriteInputTokenCount,optional"`
+	InputTokenCostUSD           float64          `json:"inputTokenCostUSD" parquet:"inputTokenCostUSD"`
+	OutputTokenCostUSD          float64          `json:"outputTokenCostUSD" parquet:"outputTokenCostUSD"`
+	CacheReadInputTokenCostUSD  float64          `json:"cacheReadInputTokenCostUSD,omitempty" parquet:"cacheReadInputTokenCostUSD,optional"`
+	CacheWriteInputTokenCostUSD float64          `json:"cacheWriteInputTokenCostUSD,omitempty" parquet:"cacheWriteInputTokenCostUSD,optional"`
+	CacheSavingsUSD             float64          `json:"cacheSavingsUSD,omitempty" parquet:"cacheSavingsUSD,optional"`
+	PricingUnit                 string           `json:"pricingUnit,omitempty" parquet:"pricingUnit,optional"`
+	UnitCount                   float64          `json:"unitCount,omitempty" parquet:"unitCount,optional"`
+	UnitCostUSD                 float64          `json:"unitCostUSD,omitempty" parquet:"unitCostUSD,optional"`
+	InvocationLatency           int              `json:"invocationLatency,omitempty" parquet:"invocationLatency,optional"`
+	FirstByteLatency            int              `json:"firstByteLatency,omitempty" parquet:"firstByteLatency,optional"`
+	EnergyConsumptionkWh        float64          `json:"energyConsumptionkWh,omitempty" parquet:"energyConsumptionkWh,optional"`
+	CarbonEmissiongCO2e         float64          `json:"carbonEmissiongCO2e,omitempty" parquet:"carbonEmissiongCO2e,optional"`
+}
+
+// TotalCostUSD is the estimated cost of the invocation
+func (m *InvocationLogMetadata) TotalCostUSD() float64 {
+	return m.InputTokenCostUSD + m.OutputTokenCostUSD + m.CacheReadInputTokenCostUSD + m.CacheWriteInputTokenCostUSD + m.UnitCostUSD
 }
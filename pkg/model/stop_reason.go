@@ -0,0 +1,50 @@
+package model
+
+import "strings"
+
+// Normalized reasons why a model stopped generating
+const (
+	StopReasonEndTurn         = "end_turn"
+	StopReasonMaxTokens       = "max_tokens"
+	StopReasonStopSequence    = "stop_sequence"
+	StopReasonContentFiltered = "content_filtered"
+	StopReasonToolUse         = "tool_use"
+	StopReasonError           = "error"
+)
+
+// nativeStopReasons maps the lowercased stop, finish and completion reasons of the
+// providers and of the Converse API to normalized stop reasons
+var nativeStopReasons = map[string]string{
+	// Converse, Anthropic and Nova
+	"end_turn":             StopReasonEndTurn,
+	"max_tokens":           StopReasonMaxTokens,
+	"stop_sequence":        StopReasonStopSequence,
+	"tool_use":             StopReasonToolUse,
+	"guardrail_intervened": StopReasonContentFiltered,
+	"content_filtered":     StopReasonContentFiltered,
+	// Titan
+	"finish":            StopReasonEndTurn,
+	"length":            StopReasonMaxTokens,
+	"stop_criteria_met": StopReasonStopSequence,
+	// Llama, Mistral, Jamba and Jurassic-2
+	"stop":         StopReasonEndTurn,
+	"model_length": StopReasonMaxTokens,
+	"tool_calls":   StopReasonToolUse,
+	"endoftext":    StopReasonEndTurn,
+	// Cohere
+	"complete":    StopReasonEndTurn,
+	"error_toxic": StopReasonContentFiltered,
+	"error_limit": StopReasonMaxTokens,
+	"error":       StopReasonError,
+	"user_cancel": StopReasonError,
+}
+
+// normalizeStopReason returns the normalized stop reason of a native one. Unknown
+// reasons are returned lowercased, so they are still reported.
+func normalizeStopReason(native string) string {
+	native = strings.ToLower(strings.TrimSpace(native))
+	if stopReason, ok := nativeStopReasons[native]; ok {
+		return stopReason
+	}
+	return native
+}
@@ -0,0 +1,61 @@
+package model
+
+import (
+	"testing"
+)
+
+func TestNormalizeStopReason(t *testing.T) {
+	tests := map[string]string{
+		"end_turn":             StopReasonEndTurn,
+		"FINISH":               StopReasonEndTurn,
+		"COMPLETE":             StopReasonEndTurn,
+		"LENGTH":               StopReasonMaxTokens,
+		"length":               StopReasonMaxTokens,
+		"MAX_TOKENS":           StopReasonMaxTokens,
+		"stop_sequence":        StopReasonStopSequence,
+		"CONTENT_FILTERED":     StopReasonContentFiltered,
+		"guardrail_intervened": StopReasonContentFiltered,
+		"tool_calls":           StopReasonToolUse,
+		"":                     "",
+		"Something_New":        "something_new",
+	}
+
+	for native, wanted := range tests {
+		if got := normalizeStopReason(native); got != wanted {
+			t.Errorf("%q: got %q, wanted %q", native, got, wanted)
+		}
+	}
+}
+
+func TestMetadataGenerator_GenerateModelInvocationLogMetadataTruncated(t *testing.T) {
+	modelMetaDataGenerator := newTestGenerator(t, GeneratorOptions{})
+
+	tests := []struct {
+		outputBody       map[string]any
+		wantedStopReason string
+		wantedTruncated  bool
+	}{
+		{outputBody: map[string]any{"content": []any{}, "stop_reason": "max_tokens"}, wantedStopReason: StopReasonMaxTokens, wantedTruncated: true},
+		{outputBody: map[string]any{"content": []any{}, "stop_reason": "end_turn"}, wantedStopReason: StopReasonEndTurn},
+	}
+
+	for _, test := range tests {
+		invocationLog := &InvocationLog{
+			Region:    "us-east-1",
+			Operation: OperationInvokeModel,
+			ModelID:   "anthropic.claude-3-haiku-20240307-v1:0",
+		}
+		invocationLog.Output.OutputBodyJSON = test.outputBody
+
+		metadata, err := modelMetaDataGenerator.GenerateModelInvocationLogMetadata(invocationLog)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if metadata.StopReason != test.wantedStopReason {
+			t.Errorf("got %q, wanted %q", metadata.StopReason, test.wantedStopReason)
+		}
+		if metadata.Truncated != test.wantedTruncated {
+			t.Errorf("got %t, wanted %t", metadata.Truncated, test.wantedTruncated)
+		}
+	}
+}
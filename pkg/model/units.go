@@ -0,0 +1,158 @@
+package model
+
+import (
+	"strconv"
+	"strings"
+)
+
+// Pricing units of models that are not priced by tokens
+const (
+	PricingUnitImage   = "image"
+	PricingUnitSecond  = "second"
+	PricingUnitRequest = "request"
+)
+
+// UnitPrice is the price of one image, second or request. Image prices may be limited
+// to a maximum resolution and number of steps, or to a quality; the first price in
+// models.json that matches an invocation applies.
+type UnitPrice struct {
+	Unit      string  `json:"unit"`
+	Price     float64 `json:"price"`
+	MaxWidth  int     `json:"max_width,omitempty"`
+	MaxHeight int     `json:"max_height,omitempty"`
+	MaxSteps  int     `json:"max_steps,omitempty"`
+	Quality   string  `json:"quality,omitempty"`
+}
+
+// UnitUsage is the usage of an invocation in units other than tokens, extracted from
+// its request and response bodies. Unknown values are zero.
+type UnitUsage struct {
+	Images  int
+	Width   int
+	Height  int
+	Steps   int
+	Quality string
+	Seconds float64
+}
+
+// Count returns the number of units of the given pricing unit
+func (u UnitUsage) Count(unit string) float64 {
+	switch unit {
+	case PricingUnitImage:
+		// A successful invocation generated at least one image, even when image data
+		// is excluded from the logs
+		return float64(max(u.Images, 1))
+	case PricingUnitSecond:
+		return u.Seconds
+	case PricingUnitRequest:
+		return 1
+	default:
+		return 0
+	}
+}
+
+// Default resolution of the image models on Bedrock, assumed when a request does not
+// set its width or height
+const (
+	defaultImageWidth  = 1024
+	defaultImageHeight = 1024
+)
+
+func (p *UnitPrice) matches(usage UnitUsage) bool {
+	width, height := usage.Width, usage.Height
+	if width == 0 {
+		width = defaultImageWidth
+	}
+	if height == 0 {
+		height = defaultImageHeight
+	}
+	if p.MaxWidth != 0 && width > p.MaxWidth {
+		return false
+	}
+	if p.MaxHeight != 0 && height > p.MaxHeight {
+		return false
+	}
+	if p.MaxSteps != 0 && usage.Steps > p.MaxSteps {
+		return false
+	}
+	if p.Quality != "" && usage.Quality != "" && !strings.EqualFold(p.Quality, usage.Quality) {
+		return false
+	}
+	return true
+}
+
+// extractUnitUsage reads image counts, dimensions, steps, quality and durations from
+// the request and response bodies of Stability AI, Amazon Titan and Nova image models
+// and of video models. Prompts and images are never read.
+func extractUnitUsage(inputBody, outputBody any) UnitUsage {
+	usage := UnitUsage{
+		Width:   int(jsonNumber(inputBody, "width", "imageGenerationConfig.width")),
+		Height:  int(jsonNumber(inputBody, "height", "imageGenerationConfig.height")),
+		Steps:   int(jsonNumber(inputBody, "steps")),
+		Quality: jsonString(inputBody, "imageGenerationConfig.quality"),
+		Seconds: jsonNumber(inputBody, "durationSeconds", "duration", "videoGenerationConfig.durationSeconds"),
+	}
+
+	usage.Images = jsonArrayLength(outputBody, "images", "artifacts")
+	if usage.Images == 0 {
+		usage.Images = int(jsonNumber(inputBody, "imageGenerationConfig.numberOfImages", "samples"))
+	}
+
+	return usage
+}
+
+// jsonValue returns the value at a dot separated path of a decoded JSON body. Numeric
+// path elements index arrays.
+func jsonValue(body any, path string) any {
+	for _, key := range strings.Split(path, ".") {
+		switch value := body.(type) {
+		case map[string]any:
+			body = value[key]
+		case []any:
+			index, err := strconv.Atoi(key)
+			if err != nil || index < 0 || index >= len(value) {
+				return nil
+			}
+			body = value[index]
+		default:
+			return nil
+		}
+	}
+	return body
+}
+
+// jsonNumber returns the first number found at paths. Durations such as "5s" are
+// read as numbers of seconds.
+func jsonNumber(body any, paths ...string) float64 {
+	for _, path := range paths {
+		switch value := jsonValue(body, path).(type) {
+		case float64:
+			return value
+		case string:
+			if number, err := strconv.ParseFloat(strings.TrimSuffix(value, "s"), 64); err == nil {
+				return number
+			}
+		}
+	}
+	return 0
+}
+
+// jsonString returns the first string found at paths
+func jsonString(body any, paths ...string) string {
+	for _, path := range paths {
+		if value, ok := jsonValue(body, path).(string); ok {
+			return value
+		}
+	}
+	return ""
+}
+
+// jsonArrayLength returns the length of the first array found at paths
+func jsonArrayLength(body any, paths ...string) int {
+	for _, path := range paths {
+		if value, ok := jsonValue(body, path).([]any); ok {
+			return len(value)
+		}
+	}
+	return 0
+}
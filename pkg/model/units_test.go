@@ -0,0 +1,105 @@
+package model
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func decodeBody(t *testing.T, body string) any {
+	t.Helper()
+
+	var decoded any
+	if err := json.Unmarshal([]byte(body), &decoded); err != nil {
+		t.Fatal(err)
+	}
+	return decoded
+}
+
+func TestExtractUnitUsage(t *testing.T) {
+	tests := []struct {
+		name   string
+		input  string
+		output string
+		wanted UnitUsage
+	}{
+		{
+			name:   "titan image",
+			input:  `{"taskType": "TEXT_IMAGE", "imageGenerationConfig": {"numberOfImages": 3, "quality": "standard", "width": 512, "height": 512}}`,
+			output: `{}`,
+			wanted: UnitUsage{Images: 3, Width: 512, Height: 512, Quality: "standard"},
+		},
+		{
+			name:   "stability sdxl",
+			input:  `{"width": 1024, "height": 768, "steps": 30, "samples": 1}`,
+			output: `{"artifacts": [{"base64": "a"}, {"base64": "b"}]}`,
+			wanted: UnitUsage{Images: 2, Width: 1024, Height: 768, Steps: 30},
+		},
+		{
+			name:   "stability sd3",
+			input:  `{"aspect_ratio": "16:9"}`,
+			output: `{"images": ["a"], "seeds": [1]}`,
+			wanted: UnitUsage{Images: 1},
+		},
+		{
+			name:   "video",
+			input:  `{"duration": "5s", "resolution": "720p"}`,
+			output: `null`,
+			wanted: UnitUsage{Seconds: 5},
+		},
+	}
+
+	for _, test := range tests {
+		got := extractUnitUsage(decodeBody(t, test.input), decodeBody(t, test.output))
+		if got != test.wanted {
+			t.Errorf("%s: got %+v, wanted %+v", test.name, got, test.wanted)
+		}
+	}
+}
+
+func TestCostEstimator_EstimateModelInvocationUnitCost(t *testing.T) {
+	prices := `{
+	  "video": {"cost": [{"region": "any", "unit_prices": [{"unit": "second", "price": 0.5}]}]},
+	  "rerank": {"cost": [{"region": "any", "unit_prices": [{"unit": "request", "price": 0.002}]}]},
+	  "image": {"cost": [{"region": "any", "unit_prices": [
+	    {"unit": "image", "max_width": 512, "max_height": 512, "price": 0.01},
+	    {"unit": "image", "price": 0.02}
+	  ]}]}
+	}`
+
+	costEstimator, err := NewCostEstimator([]byte(prices))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		modelID string
+		usage   UnitUsage
+		wanted  float64
+	}{
+		{modelID: "video", usage: UnitUsage{Seconds: 6}, wanted: 3},
+		{modelID: "rerank", usage: UnitUsage{}, wanted: 0.002},
+		{modelID: "image", usage: UnitUsage{Images: 2, Width: 512, Height: 512}, wanted: 0.02},
+		{modelID: "image", usage: UnitUsage{Images: 2, Width: 1024, Height: 1024}, wanted: 0.04},
+		// Image data excluded from the logs still counts as one image, and a request
+		// without a width or height generates an image of the default resolution
+		{modelID: "image", usage: UnitUsage{}, wanted: 0.02},
+		{modelID: "image", usage: UnitUsage{Width: 512}, wanted: 0.02},
+	}
+
+	for _, test := range tests {
+		metadata := costEstimator.EstimateModelInvocationUnitCost(&InvocationLogMetadata{ModelID: test.modelID}, test.usage)
+		if metadata.UnitCostUSD != test.wanted {
+			t.Errorf("%s %+v: got %f, wanted %f", test.modelID, test.usage, metadata.UnitCostUSD, test.wanted)
+		}
+	}
+
+	// Images generated with provisioned throughput are counted but not priced
+	metadata := costEstimator.EstimateModelInvocationUnitCost(&InvocationLogMetadata{ModelID: "image", PricingMode: PricingModeProvisioned}, UnitUsage{Images: 2, Width: 1024, Height: 1024})
+	if metadata.UnitCostUSD != 0 || metadata.UnitCount != 2 {
+		t.Errorf("got %f for %f images, wanted no cost for 2 images", metadata.UnitCostUSD, metadata.UnitCount)
+	}
+
+	if _, err := NewCostEstimator([]byte(`{"m": {"cost": [{"region": "any", "unit_prices": [{"unit": "pixel"}]}]}}`)); err == nil {
+		t.Errorf("expected an error for an unsupported pricing unit")
+	}
+}
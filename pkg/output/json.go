@@ -0,0 +1,48 @@
+package output
+
+import (
+	"compress/gzip"
+	"encoding/json"
+	"io"
+
+	"github.com/greenscale-ai/amazon-bedrock-metadata/pkg/model"
+)
+
+type jsonEncoder struct {
+	gz      *gzip.Writer
+	encoder *json.Encoder
+}
+
+func newJSONEncoder(w io.Writer) *jsonEncoder {
+	gz := gzip.NewWriter(w)
+	return &jsonEncoder{gz: gz, encoder: json.NewEncoder(gz)}
+}
+
+// Encode terminates each record with a newline
+func (e *jsonEncoder) Encode(metadata *model.InvocationLogMetadata) error {
+	return e.encoder.Encode(metadata)
+}
+
+func (e *jsonEncoder) Close() error {
+	return e.gz.Close()
+}
+
+func readJSON(body io.Reader) ([]model.InvocationLogMetadata, error) {
+	gz, err := gzip.NewReader(body)
+	if err != nil {
+		return nil, err
+	}
+	defer gz.Close()
+
+	var metadata []model.InvocationLogMetadata
+	decoder := json.NewDecoder(gz)
+	for {
+		var record model.InvocationLogMetadata
+		if err := decoder.Decode(&record); err == io.EOF {
+			return metadata, nil
+		} else if err != nil {
+			return nil, err
+		}
+		metadata = append(metadata, record)
+	}
+}
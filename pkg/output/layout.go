@@ -0,0 +1,135 @@
+package output
+
+import (
+	"fmt"
+	"path"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// Layout is the key layout of the metadata objects written to the sink
+type Layout string
+
+const (
+	// LayoutSource keeps the key of the source log object:
+	// AWSLogs/<account>/BedrockModelInvocationLogs/<region>/YYYY/MM/DD/HH/<file>
+	LayoutSource Layout = "source"
+	// LayoutHive writes Hive-style partitions that Athena partition projection can prune:
+	// account=<account>/region=<region>/year=YYYY/month=MM/day=DD/hour=HH/<file>
+	LayoutHive Layout = "hive"
+)
+
+// sourceKeyPattern matches the key of a model invocation log object, with any input prefix
+var sourceKeyPattern = regexp.MustCompile(`^(?:(.*)/)?AWSLogs/([^/]+)/BedrockModelInvocationLogs/([^/]+)/(\d{4})/(\d{2})/(\d{2})/(\d{2})/(.+)$`)
+
+// BatchOutputSuffix is the key suffix of the record files written by batch inference
+// jobs under <output prefix>/<job ID>/
+const BatchOutputSuffix = ".jsonl.out"
+
+// BatchJobID returns the ID of the batch inference job that wrote the output object
+// key, or false when key is not a batch inference output
+func BatchJobID(key string) (string, bool) {
+	if !strings.HasSuffix(key, BatchOutputSuffix) {
+		return "", false
+	}
+
+	jobID := path.Base(path.Dir(key))
+	if jobID == "." || jobID == "/" {
+		return "", false
+	}
+	return jobID, true
+}
+
+// SourceKey is a parsed model invocation log object key
+type SourceKey struct {
+	Prefix    string
+	AccountID string
+	Region    string
+	Hour      time.Time
+	Name      string
+}
+
+// ParseSourceKey parses a model invocation log object key:
+// [prefix/]AWSLogs/<account>/BedrockModelInvocationLogs/<region>/YYYY/MM/DD/HH/<name>
+func ParseSourceKey(key string) (SourceKey, error) {
+	match := sourceKeyPattern.FindStringSubmatch(key)
+	if match == nil {
+		return SourceKey{}, fmt.Errorf("%q is not a model invocation log key", key)
+	}
+
+	hour, err := time.Parse("2006/01/02/15", strings.Join(match[4:8], "/"))
+	if err != nil {
+		return SourceKey{}, fmt.Errorf("%q is not a model invocation log key, %v", key, err)
+	}
+
+	return SourceKey{Prefix: match[1], AccountID: match[2], Region: match[3], Hour: hour, Name: match[8]}, nil
+}
+
+// ParseLayout parses a configured output key layout. An empty value selects the
+// source layout.
+func ParseLayout(value string) (Layout, error) {
+	switch Layout(strings.ToLower(strings.TrimSpace(value))) {
+	case "", LayoutSource:
+		return LayoutSource, nil
+	case LayoutHive:
+		return LayoutHive, nil
+	default:
+		return "", fmt.Errorf("unsupported output key layout %q", value)
+	}
+}
+
+// ObjectKey returns the key of the metadata object generated from sourceKey,
+// following the configured layout and prefix.
+func (o Options) ObjectKey(sourceKey string) (string, error) {
+	key := o.Format.ObjectKey(sourceKey)
+
+	if o.Layout == LayoutHive {
+		parsedKey, err := ParseSourceKey(key)
+		if err != nil {
+			return "", fmt.Errorf("unable to derive partitions from key, %v", err)
+		}
+		key = o.hourPath(parsedKey) + parsedKey.Name
+	}
+
+	return o.withPrefix(key), nil
+}
+
+// BatchObjectKey returns the key of the metadata object generated from the batch
+// inference output sourceKey. The Hive layout partitions it by the account, region
+// and hour of its job.
+func (o Options) BatchObjectKey(sourceKey, accountID, region string, hour time.Time) string {
+	key := o.Format.ObjectKey(sourceKey)
+
+	if o.Layout == LayoutHive {
+		jobID, _ := BatchJobID(sourceKey)
+		key = o.hourPath(SourceKey{AccountID: accountID, Region: region, Hour: hour.UTC().Truncate(time.Hour)}) + jobID + "/" + path.Base(key)
+	}
+
+	return o.withPrefix(key)
+}
+
+// HourPrefix returns the key prefix of the metadata objects generated from the model
+// invocation logs of an hour
+func (o Options) HourPrefix(inputPrefix, accountID, region string, hour time.Time) string {
+	return o.withPrefix(o.hourPath(SourceKey{Prefix: inputPrefix, AccountID: accountID, Region: region, Hour: hour}))
+}
+
+func (o Options) hourPath(key SourceKey) string {
+	if o.Layout == LayoutHive {
+		return fmt.Sprintf("account=%s/region=%s/%s", key.AccountID, key.Region, key.Hour.Format("year=2006/month=01/day=02/hour=15/"))
+	}
+
+	hourPath := fmt.Sprintf("AWSLogs/%s/BedrockModelInvocationLogs/%s/%s", key.AccountID, key.Region, key.Hour.Format("2006/01/02/15/"))
+	if prefix := strings.Trim(key.Prefix, "/"); prefix != "" {
+		hourPath = prefix + "/" + hourPath
+	}
+	return hourPath
+}
+
+func (o Options) withPrefix(key string) string {
+	if prefix := strings.Trim(o.Prefix, "/"); prefix != "" {
+		return prefix + "/" + key
+	}
+	return key
+}
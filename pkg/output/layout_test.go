@@ -0,0 +1,141 @@
+package output
+
+import (
+	"testing"
+	"time"
+)
+
+func TestObjectKeyLayout(t *testing.T) {
+	sourceKey := "logs/AWSLogs/123456789012/BedrockModelInvocationLogs/us-east-1/2024/05/01/10/20240501T103015_abc.json.gz"
+
+	tests := []struct {
+		options Options
+		wanted  string
+	}{
+		{
+			options: Options{Format: FormatJSON, Layout: LayoutSource},
+			wanted:  sourceKey,
+		},
+		{
+			options: Options{Format: FormatJSON, Layout: LayoutSource, Prefix: "/metadata/"},
+			wanted:  "metadata/" + sourceKey,
+		},
+		{
+			options: Options{Format: FormatJSON, Layout: LayoutHive},
+			wanted:  "account=123456789012/region=us-east-1/year=2024/month=05/day=01/hour=10/20240501T103015_abc.json.gz",
+		},
+		{
+			options: Options{Format: FormatParquet, Layout: LayoutHive, Prefix: "metadata"},
+			wanted:  "metadata/account=123456789012/region=us-east-1/year=2024/month=05/day=01/hour=10/20240501T103015_abc.parquet",
+		},
+	}
+
+	for _, test := range tests {
+		got, err := test.options.ObjectKey(sourceKey)
+		if err != nil {
+			t.Fatalf("%+v: %v", test.options, err)
+		}
+		if got != test.wanted {
+			t.Errorf("got %q, wanted %q", got, test.wanted)
+		}
+	}
+}
+
+func TestObjectKeyLayoutInvalidKey(t *testing.T) {
+	options := Options{Format: FormatJSON, Layout: LayoutHive}
+	if _, err := options.ObjectKey("AWSLogs/123456789012/other.json.gz"); err == nil {
+		t.Errorf("expected an error for a key without partitions")
+	}
+}
+
+func TestParseLayout(t *testing.T) {
+	tests := map[string]Layout{"": LayoutSource, "source": LayoutSource, "HIVE": LayoutHive}
+	for value, wanted := range tests {
+		got, err := ParseLayout(value)
+		if err != nil {
+			t.Fatalf("failed to parse %q: %v", value, err)
+		}
+		if got != wanted {
+			t.Errorf("got %q, wanted %q", got, wanted)
+		}
+	}
+
+	if _, err := ParseLayout("flat"); err == nil {
+		t.Errorf("expected an error for an unsupported layout")
+	}
+}
+
+func TestParseSourceKey(t *testing.T) {
+	got, err := ParseSourceKey("logs/bedrock/AWSLogs/123456789012/BedrockModelInvocationLogs/us-east-1/2024/05/01/10/20240501T103015_abc.json.gz")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	wanted := SourceKey{
+		Prefix:    "logs/bedrock",
+		AccountID: "123456789012",
+		Region:    "us-east-1",
+		Hour:      time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC),
+		Name:      "20240501T103015_abc.json.gz",
+	}
+	if got != wanted {
+		t.Errorf("got %+v, wanted %+v", got, wanted)
+	}
+
+	if _, err := ParseSourceKey("AWSLogs/123456789012/BedrockModelInvocationLogs/us-east-1/2024/13/01/10/a.json.gz"); err == nil {
+		t.Errorf("expected an error for an invalid month")
+	}
+}
+
+func TestHourPrefix(t *testing.T) {
+	hour := time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)
+
+	source := Options{Layout: LayoutSource, Prefix: "metadata"}
+	wanted := "metadata/logs/AWSLogs/123456789012/BedrockModelInvocationLogs/us-east-1/2024/05/01/10/"
+	if got := source.HourPrefix("logs", "123456789012", "us-east-1", hour); got != wanted {
+		t.Errorf("got %q, wanted %q", got, wanted)
+	}
+
+	hive := Options{Layout: LayoutHive}
+	wanted = "account=123456789012/region=us-east-1/year=2024/month=05/day=01/hour=10/"
+	if got := hive.HourPrefix("logs", "123456789012", "us-east-1", hour); got != wanted {
+		t.Errorf("got %q, wanted %q", got, wanted)
+	}
+}
+
+func TestBatchObjectKey(t *testing.T) {
+	sourceKey := "batch/output/abcd1234efgh/records.jsonl.jsonl.out"
+	hour := time.Date(2024, 5, 1, 10, 42, 0, 0, time.UTC)
+
+	tests := []struct {
+		options Options
+		wanted  string
+	}{
+		{
+			options: Options{Format: FormatJSON, Layout: LayoutSource},
+			wanted:  "batch/output/abcd1234efgh/records.jsonl.json.gz",
+		},
+		{
+			options: Options{Format: FormatParquet, Layout: LayoutHive, Prefix: "metadata"},
+			wanted:  "metadata/account=123456789012/region=us-east-1/year=2024/month=05/day=01/hour=10/abcd1234efgh/records.jsonl.parquet",
+		},
+	}
+
+	for _, test := range tests {
+		got := test.options.BatchObjectKey(sourceKey, "123456789012", "us-east-1", hour)
+		if got != test.wanted {
+			t.Errorf("got %q, wanted %q", got, test.wanted)
+		}
+	}
+}
+
+func TestBatchJobID(t *testing.T) {
+	jobID, ok := BatchJobID("batch/output/abcd1234efgh/records.jsonl.jsonl.out")
+	if !ok || jobID != "abcd1234efgh" {
+		t.Errorf("got %q, wanted %q", jobID, "abcd1234efgh")
+	}
+
+	if _, ok := BatchJobID("batch/output/abcd1234efgh/manifest.json.out"); ok {
+		t.Errorf("expected the job manifest not to be a batch output")
+	}
+}
@@ -0,0 +1,101 @@
+package output
+
+import (
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/greenscale-ai/amazon-bedrock-metadata/pkg/model"
+)
+
+// Format is the file format of the metadata objects written to the sink
+type Format string
+
+const (
+	// FormatJSON writes gzipped JSON lines, one record per line
+	FormatJSON Format = "json"
+	// FormatParquet writes Snappy compressed Parquet files
+	FormatParquet Format = "parquet"
+)
+
+// ParseFormat parses a configured output format. An empty value selects JSON.
+func ParseFormat(value string) (Format, error) {
+	switch Format(strings.ToLower(strings.TrimSpace(value))) {
+	case "", FormatJSON:
+		return FormatJSON, nil
+	case FormatParquet:
+		return FormatParquet, nil
+	default:
+		return "", fmt.Errorf("unsupported output format %q", value)
+	}
+}
+
+// Options configures how metadata objects are written
+type Options struct {
+	Format Format
+	Layout Layout
+	// Prefix is prepended to every metadata object key
+	Prefix string
+}
+
+// Encoder writes metadata records to an output object. Close must be called to
+// flush buffered records; it does not close the underlying writer.
+type Encoder interface {
+	Encode(metadata *model.InvocationLogMetadata) error
+	Close() error
+}
+
+// NewEncoder returns an encoder writing records to w in the given format
+func NewEncoder(format Format, w io.Writer) Encoder {
+	if format == FormatParquet {
+		return newParquetEncoder(w)
+	}
+	return newJSONEncoder(w)
+}
+
+// ReadMetadata reads back the records of a metadata object, in the format given by
+// its key's extension
+func ReadMetadata(key string, body io.Reader) ([]model.InvocationLogMetadata, error) {
+	if strings.HasSuffix(key, ".parquet") {
+		return readParquet(body)
+	}
+	return readJSON(body)
+}
+
+// ContentType returns the Content-Type of objects in the format
+func (f Format) ContentType() string {
+	if f == FormatParquet {
+		return "application/vnd.apache.parquet"
+	}
+	return "application/json"
+}
+
+// ContentEncoding returns the Content-Encoding of objects in the format, if any.
+// Parquet compresses its pages internally, so the object itself is not encoded.
+func (f Format) ContentEncoding() string {
+	if f == FormatParquet {
+		return ""
+	}
+	return "gzip"
+}
+
+// ObjectKey returns the key of the metadata object generated from sourceKey. JSON
+// output keeps the source key; Parquet output replaces its extension.
+func (f Format) ObjectKey(sourceKey string) string {
+	if strings.HasSuffix(sourceKey, BatchOutputSuffix) {
+		// Batch inference output is plain JSON lines, unlike the metadata objects
+		key := strings.TrimSuffix(sourceKey, BatchOutputSuffix)
+		if f == FormatParquet {
+			return key + ".parquet"
+		}
+		return key + ".json.gz"
+	}
+
+	if f != FormatParquet {
+		return sourceKey
+	}
+
+	key := strings.TrimSuffix(sourceKey, ".gz")
+	key = strings.TrimSuffix(key, ".json")
+	return key + ".parquet"
+}
@@ -0,0 +1,288 @@
+package output
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"io"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/greenscale-ai/amazon-bedrock-metadata/pkg/model"
+	"github.com/parquet-go/parquet-go"
+)
+
+func testRecords() []model.InvocationLogMetadata {
+	first := model.InvocationLogMetadata{
+		Timestamp:            time.Date(2024, 5, 1, 10, 30, 15, 123000000, time.UTC),
+		AccountID:            "123456789012",
+		Identity:             model.Identity{Arn: "arn:aws:iam::123456789012:user/alice"},
+		IdentityTags:         []model.IdentityTag{{Key: "team", Value: "search"}, {Key: "env", Value: "prod"}},
+		ResourceTags:         []model.ResourceTag{{Key: "cost-center", Value: "1234"}},
+		Region:               "us-east-1",
+		RequestID:            "request-1",
+		Operation:            "InvokeModel",
+		ModelID:              "meta.llama2-13b-chat-v1",
+		ModelName:            "Llama 2 Chat 13B",
+		ModelProvider:        "Meta",
+		InputContentType:     "application/json",
+		OutputContentType:    "application/json",
+		InputTokenCount:      12,
+		OutputTokenCount:     340,
+		InputTokenCostUSD:    0.0000090,
+		OutputTokenCostUSD:   0.000340,
+		InvocationLatency:    1200,
+		EnergyConsumptionkWh: 0.00012,
+		CarbonEmissiongCO2e:  0.048,
+	}
+	second := model.InvocationLogMetadata{
+		Timestamp:       time.Date(2024, 5, 1, 10, 31, 0, 0, time.UTC),
+		AccountID:       "123456789012",
+		Identity:        model.Identity{Arn: "arn:aws:iam::123456789012:role/service"},
+		Region:          "us-east-1",
+		RequestID:       "request-2",
+		Operation:       "InvokeModelWithResponseStream",
+		ModelID:         "amazon.titan-text-lite-v1",
+		ModelName:       "Titan Text G1 - Lite",
+		InputTokenCount: 5,
+	}
+	return []model.InvocationLogMetadata{first, second}
+}
+
+func encode(t *testing.T, format Format, records []model.InvocationLogMetadata) []byte {
+	t.Helper()
+
+	var buf bytes.Buffer
+	encoder := NewEncoder(format, &buf)
+	for i := range records {
+		if err := encoder.Encode(&records[i]); err != nil {
+			t.Fatalf("failed to encode record: %v", err)
+		}
+	}
+	if err := encoder.Close(); err != nil {
+		t.Fatalf("failed to close encoder: %v", err)
+	}
+	return buf.Bytes()
+}
+
+func TestParquetRoundTrip(t *testing.T) {
+	records := testRecords()
+	data := encode(t, FormatParquet, records)
+
+	reader := parquet.NewGenericReader[model.InvocationLogMetadata](bytes.NewReader(data))
+	defer reader.Close()
+
+	if reader.NumRows() != int64(len(records)) {
+		t.Fatalf("got %d rows, wanted %d", reader.NumRows(), len(records))
+	}
+
+	rows := make([]model.InvocationLogMetadata, len(records))
+	if n, err := reader.Read(rows); n != len(records) || (err != nil && err != io.EOF) {
+		t.Fatalf("failed to read rows, read %d, error %v", n, err)
+	}
+
+	for i := range records {
+		got, wanted := rows[i], records[i]
+		if !got.Timestamp.Equal(wanted.Timestamp) {
+			t.Errorf("got timestamp %v, wanted %v", got.Timestamp, wanted.Timestamp)
+		}
+		got.Timestamp, wanted.Timestamp = time.Time{}, time.Time{}
+		// An empty repeated group reads back as nil
+		if len(wanted.IdentityTags) == 0 {
+			wanted.IdentityTags = nil
+		}
+		if len(got.IdentityTags) == 0 {
+			got.IdentityTags = nil
+		}
+		if len(wanted.ResourceTags) == 0 {
+			wanted.ResourceTags = nil
+		}
+		if len(got.ResourceTags) == 0 {
+			got.ResourceTags = nil
+		}
+		if len(got.RetainedFields) == 0 {
+			got.RetainedFields = nil
+		}
+		if !reflect.DeepEqual(got, wanted) {
+			t.Errorf("got %+v, wanted %+v", got, wanted)
+		}
+	}
+}
+
+func TestParquetRowGroups(t *testing.T) {
+	records := make([]model.InvocationLogMetadata, rowGroupRows+1)
+	for i := range records {
+		records[i] = testRecords()[i%2]
+	}
+	data := encode(t, FormatParquet, records)
+
+	file, err := parquet.OpenFile(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(file.RowGroups()) != 2 {
+		t.Errorf("got %d row groups, wanted %d", len(file.RowGroups()), 2)
+	}
+}
+
+func TestParquetSchema(t *testing.T) {
+	data := encode(t, FormatParquet, testRecords())
+
+	file, err := parquet.OpenFile(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatalf("failed to open parquet file: %v", err)
+	}
+
+	// The schema is part of the table definition in Athena, so changes must be deliberate.
+	// identityTags, resourceTags and retainedFields are annotated as a LIST in the file
+	// metadata, which String omits.
+	wanted := `message InvocationLogMetadata {
+	required int64 timestamp (TIMESTAMP(isAdjustedToUTC=true,unit=MILLIS));
+	required binary accountId (STRING);
+	required group identity {
+		required binary arn (STRING);
+	}
+	required group identityTags {
+		repeated group list {
+			required group element {
+				required binary key (STRING);
+				required binary value (STRING);
+			}
+		}
+	}
+	required group resourceTags {
+		repeated group list {
+			required group element {
+				required binary key (STRING);
+				required binary value (STRING);
+			}
+		}
+	}
+	required binary region (STRING);
+	required binary requestId (STRING);
+	required binary operation (STRING);
+	required binary modelId (STRING);
+	required binary modelName (STRING);
+	required binary modelProvider (STRING);
+	optional binary provisionedModelArn (STRING);
+	required binary pricingMode (STRING);
+	optional binary inferenceProfileId (STRING);
+	optional binary inferenceProfileGeography (STRING);
+	required binary inputContentType (STRING);
+	required binary outputContentType (STRING);
+	optional binary stopReason (STRING);
+	required boolean truncated;
+	optional int64 outputCount (INT(64,true));
+	optional group inferenceParams {
+		optional int64 maxTokens (INT(64,true));
+		optional double temperature;
+		optional double topP;
+		optional int64 topK (INT(64,true));
+		required group stopSequences {
+			repeated group list {
+				required binary element (STRING);
+			}
+		}
+	}
+	required group retainedFields {
+		repeated group list {
+			required group element {
+				required binary path (STRING);
+				required binary value (STRING);
+			}
+		}
+	}
+	optional binary promptFingerprint (STRING);
+	optional binary systemPromptFingerprint (STRING);
+	optional binary completionFingerprint (STRING);
+	required int64 inputTokenCount (INT(64,true));
+	required int64 outputTokenCount (INT(64,true));
+	optional int64 cacheReadInputTokenCount (INT(64,true));
+	optional int64 cacheWriteInputTokenCount (INT(64,true));
+	required double inputTokenCostUSD;
+	required double outputTokenCostUSD;
+	optional double cacheReadInputTokenCostUSD;
+	optional double cacheWriteInputTokenCostUSD;
+	optional double cacheSavingsUSD;
+	optional binary pricingUnit (STRING);
+	optional double unitCount;
+	optional double unitCostUSD;
+	optional int64 invocationLatency (INT(64,true));
+	optional int64 firstByteLatency (INT(64,true));
+	optional double energyConsumptionkWh;
+	optional double carbonEmissiongCO2e;
+}`
+	if got := file.Schema().String(); got != wanted {
+		t.Errorf("got schema\n%s\nwanted\n%s", got, wanted)
+	}
+}
+
+func TestJSONRoundTrip(t *testing.T) {
+	records := testRecords()
+	data := encode(t, FormatJSON, records)
+
+	gz, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("failed to decompress output: %v", err)
+	}
+
+	decoder := json.NewDecoder(gz)
+	for i := range records {
+		var got model.InvocationLogMetadata
+		if err := decoder.Decode(&got); err != nil {
+			t.Fatalf("failed to decode record %d: %v", i, err)
+		}
+		if got.RequestID != records[i].RequestID {
+			t.Errorf("got %q, wanted %q", got.RequestID, records[i].RequestID)
+		}
+	}
+}
+
+func TestReadMetadata(t *testing.T) {
+	records := testRecords()
+
+	for key, format := range map[string]Format{"logs.json.gz": FormatJSON, "logs.parquet": FormatParquet} {
+		metadata, err := ReadMetadata(key, bytes.NewReader(encode(t, format, records)))
+		if err != nil {
+			t.Fatalf("%s: %v", key, err)
+		}
+
+		if len(metadata) != len(records) {
+			t.Fatalf("%s: got %d records, wanted %d", key, len(metadata), len(records))
+		}
+		if metadata[1].RequestID != records[1].RequestID {
+			t.Errorf("%s: got %q, wanted %q", key, metadata[1].RequestID, records[1].RequestID)
+		}
+	}
+}
+
+func TestParseFormat(t *testing.T) {
+	tests := map[string]Format{"": FormatJSON, "json": FormatJSON, "Parquet": FormatParquet}
+	for value, wanted := range tests {
+		got, err := ParseFormat(value)
+		if err != nil {
+			t.Fatalf("failed to parse %q: %v", value, err)
+		}
+		if got != wanted {
+			t.Errorf("got %q, wanted %q", got, wanted)
+		}
+	}
+
+	if _, err := ParseFormat("csv"); err == nil {
+		t.Errorf("expected an error for an unsupported format")
+	}
+}
+
+func TestObjectKey(t *testing.T) {
+	key := "AWSLogs/123456789012/BedrockModelInvocationLogs/us-east-1/2024/05/01/10/20240501T103015_abc.json.gz"
+
+	if got := FormatJSON.ObjectKey(key); got != key {
+		t.Errorf("got %q, wanted %q", got, key)
+	}
+
+	wanted := "AWSLogs/123456789012/BedrockModelInvocationLogs/us-east-1/2024/05/01/10/20240501T103015_abc.parquet"
+	if got := FormatParquet.ObjectKey(key); got != wanted {
+		t.Errorf("got %q, wanted %q", got, wanted)
+	}
+}
@@ -0,0 +1,47 @@
+package output
+
+import (
+	"bytes"
+	"io"
+
+	"github.com/greenscale-ai/amazon-bedrock-metadata/pkg/model"
+	"github.com/parquet-go/parquet-go"
+)
+
+// rowGroupRows bounds the rows buffered in memory before a row group is flushed. A
+// row group is buffered encoded, at a few hundred bytes per metadata record, on top of
+// about 6 MB of page buffers per encoder, so an encoder holds about 10 MB at most.
+// The processor writes up to 40 objects at once, 10 for each of 4 hours.
+const rowGroupRows = 10000
+
+type parquetEncoder struct {
+	writer *parquet.GenericWriter[model.InvocationLogMetadata]
+	row    []model.InvocationLogMetadata
+}
+
+func newParquetEncoder(w io.Writer) *parquetEncoder {
+	writer := parquet.NewGenericWriter[model.InvocationLogMetadata](w,
+		parquet.Compression(&parquet.Snappy),
+		parquet.MaxRowsPerRowGroup(rowGroupRows),
+	)
+	return &parquetEncoder{writer: writer, row: make([]model.InvocationLogMetadata, 1)}
+}
+
+func (e *parquetEncoder) Encode(metadata *model.InvocationLogMetadata) error {
+	e.row[0] = *metadata
+	_, err := e.writer.Write(e.row)
+	return err
+}
+
+func (e *parquetEncoder) Close() error {
+	return e.writer.Close()
+}
+
+// readParquet reads a whole Parquet file into memory, since its footer is at the end
+func readParquet(body io.Reader) ([]model.InvocationLogMetadata, error) {
+	data, err := io.ReadAll(body)
+	if err != nil {
+		return nil, err
+	}
+	return parquet.Read[model.InvocationLogMetadata](bytes.NewReader(data), int64(len(data)))
+}
@@ -0,0 +1,173 @@
+package processor
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/greenscale-ai/amazon-bedrock-metadata/pkg/storage"
+	"strings"
+	"sync"
+	"time"
+)
+
+// checkpointLookback is how far before the watermark objects are listed again, so
+// logs delivered late into an already processed hour are still picked up
+const checkpointLookback = 3 * time.Hour
+
+// Checkpoint records the source objects that were processed successfully, keyed by
+// object key, and the watermark hour up to which every run has succeeded. Objects
+// processed by event invocations are marked as pending a rollup of their hour, which
+// the next scheduled run writes.
+type Checkpoint struct {
+	mu        sync.Mutex
+	Watermark time.Time                  `json:"watermark"`
+	Objects   map[string]CheckpointEntry `json:"objects"`
+}
+
+type CheckpointEntry struct {
+	ETag          string    `json:"etag"`
+	Hour          time.Time `json:"hour"`
+	RollupPending bool      `json:"rollupPending,omitempty"`
+}
+
+// checkpointSaveAttempts bounds how often saving is retried when the checkpoint was
+// saved concurrently
+const checkpointSaveAttempts = 5
+
+// loadCheckpoint returns the saved checkpoint and its version
+func loadCheckpoint(checkpointStore storage.CheckpointStore) (*Checkpoint, string, error) {
+	checkpoint := &Checkpoint{Objects: make(map[string]CheckpointEntry)}
+
+	body, version, err := checkpointStore.LoadCheckpoint()
+	if err != nil || body == nil {
+		return checkpoint, version, err
+	}
+
+	if err := json.Unmarshal(body, checkpoint); err != nil {
+		return nil, "", err
+	}
+	if checkpoint.Objects == nil {
+		checkpoint.Objects = make(map[string]CheckpointEntry)
+	}
+	return checkpoint, version, nil
+}
+
+// save merges the checkpoint saved meanwhile, by event invocations or another run,
+// before saving, so their objects and watermark are kept. The save is conditional on
+// the version merged, and is merged and retried when another save came in between.
+func (c *Checkpoint) save(checkpointStore storage.CheckpointStore) error {
+	for attempt := 1; ; attempt++ {
+		err := c.saveMerged(checkpointStore)
+		if !errors.Is(err, storage.ErrCheckpointConflict) || attempt == checkpointSaveAttempts {
+			return err
+		}
+		time.Sleep(time.Duration(attempt) * 50 * time.Millisecond)
+	}
+}
+
+func (c *Checkpoint) saveMerged(checkpointStore storage.CheckpointStore) error {
+	saved, version, err := loadCheckpoint(checkpointStore)
+	if err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for key, entry := range saved.Objects {
+		if _, ok := c.Objects[key]; !ok {
+			c.Objects[key] = entry
+		}
+	}
+	if saved.Watermark.After(c.Watermark) {
+		c.Watermark = saved.Watermark
+	}
+
+	// Entries older than the lookback window are never listed again
+	for key, entry := range c.Objects {
+		if entry.Hour.Before(c.Watermark.Add(-checkpointLookback)) {
+			delete(c.Objects, key)
+		}
+	}
+
+	body, err := json.Marshal(c)
+	if err != nil {
+		return err
+	}
+	return checkpointStore.SaveCheckpoint(body, version)
+}
+
+// isProcessed reports whether the object was already processed with the same ETag.
+// Objects without an ETag, such as those of events without one, are never skipped.
+func (c *Checkpoint) isProcessed(obj storage.ObjectInfo) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entry, ok := c.Objects[obj.Key]
+	return ok && obj.ETag != "" && normalizeETag(entry.ETag) == normalizeETag(obj.ETag)
+}
+
+func (c *Checkpoint) markProcessed(obj storage.ObjectInfo, hour time.Time) {
+	if obj.ETag == "" {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.Objects[obj.Key] = CheckpointEntry{ETag: normalizeETag(obj.ETag), Hour: hour}
+}
+
+// markRollupPending marks a processed object as pending a rollup of its hour
+func (c *Checkpoint) markRollupPending(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if entry, ok := c.Objects[key]; ok {
+		entry.RollupPending = true
+		c.Objects[key] = entry
+	}
+}
+
+// isRollupPending reports whether the object was processed but its hour not yet
+// rolled up
+func (c *Checkpoint) isRollupPending(key string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.Objects[key].RollupPending
+}
+
+func (c *Checkpoint) markRolledUp(keys []string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for _, key := range keys {
+		if entry, ok := c.Objects[key]; ok {
+			entry.RollupPending = false
+			c.Objects[key] = entry
+		}
+	}
+}
+
+// normalizeETag removes the quotes S3 listings put around ETags, which S3 event
+// notifications omit
+func normalizeETag(etag string) string {
+	return strings.Trim(etag, `"`)
+}
+
+func (c *Checkpoint) advanceWatermark(hour time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if hour.After(c.Watermark) {
+		c.Watermark = hour
+	}
+}
+
+// isAfterWatermark reports whether the hour has not been covered by a successful run
+func (c *Checkpoint) isAfterWatermark(hour time.Time) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return hour.After(c.Watermark)
+}
@@ -5,183 +5,465 @@ import (
 	"bytes"
 	"compress/gzip"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"github.com/aws/aws-sdk-go/aws"
-	"github.com/aws/aws-sdk-go/service/s3"
 	"github.com/greenscale-ai/amazon-bedrock-metadata/pkg/model"
+	"github.com/greenscale-ai/amazon-bedrock-metadata/pkg/output"
+	"github.com/greenscale-ai/amazon-bedrock-metadata/pkg/storage"
+	"io"
 	"log"
 	"sync"
+	"time"
 )
 
+var gzipMagic = []byte{0x1f, 0x8b}
+
 type Processor struct {
-	modelInvocation                *model.MetadataGenerator
-	s3ClientRead                   *s3.S3
-	s3ClientWrite                  *s3.S3
-	modelInvocationLogsInputBucket string
-	metadataLogsOutputBucket       string
+	modelInvocation *model.MetadataGenerator
+	logSource       storage.LogSource
+	metadataSink    storage.MetadataSink
+	metadataStore   storage.MetadataStore
+	checkpointStore storage.CheckpointStore
+	checkpoint      *Checkpoint
+	output          output.Options
 }
 
-func NewProcessor(s3ClientRead, s3ClientWrite *s3.S3, modelInvocation *model.MetadataGenerator, modelInvocationLogsInputBucket, metadataLogsOutputBucket string) *Processor {
+// NewProcessor creates a processor reading from logSource and writing to metadataSink.
+// checkpointStore is optional; when nil every listed object is processed. Rollups are
+// only written when metadataSink can also read back metadata as a storage.MetadataStore.
+func NewProcessor(logSource storage.LogSource, metadataSink storage.MetadataSink, checkpointStore storage.CheckpointStore, modelInvocation *model.MetadataGenerator, outputOptions output.Options) *Processor {
+	if outputOptions.Format == "" {
+		outputOptions.Format = output.FormatJSON
+	}
+	if outputOptions.Layout == "" {
+		outputOptions.Layout = output.LayoutSource
+	}
+
+	metadataStore, _ := metadataSink.(storage.MetadataStore)
+
 	return &Processor{
-		s3ClientRead:                   s3ClientRead,
-		s3ClientWrite:                  s3ClientWrite,
-		modelInvocation:                modelInvocation,
-		modelInvocationLogsInputBucket: modelInvocationLogsInputBucket,
-		metadataLogsOutputBucket:       metadataLogsOutputBucket,
+		logSource:       logSource,
+		metadataSink:    metadataSink,
+		metadataStore:   metadataStore,
+		checkpointStore: checkpointStore,
+		modelInvocation: modelInvocation,
+		output:          outputOptions,
 	}
 }
 
-func (p *Processor) ProcessModelInvocationLogs(accountID, region, modelInvocationLogsInputBucketPrefix string, year, month, day, hour int) error {
-	s3Objects, err := p.listObjectsInDateRange(accountID, region, modelInvocationLogsInputBucketPrefix, year, month, day, hour)
-	if err != nil {
-		return err
+// hourWorkers bounds how many hours are processed concurrently in a date range
+const hourWorkers = 4
+
+// ProcessModelInvocationLogsInRange processes every hour from start up to, but not
+// including, end. Hours are processed concurrently and the returned report has a
+// summary for each.
+func (p *Processor) ProcessModelInvocationLogsInRange(accountID, region, modelInvocationLogsInputBucketPrefix string, start, end time.Time) (*RunReport, error) {
+	if err := p.loadCheckpoint(); err != nil {
+		return nil, err
+	}
+
+	report := newRunReport()
+	err := p.processRange(report, accountID, region, modelInvocationLogsInputBucketPrefix, start, end)
+	return report, p.finishRun(report, err)
+}
+
+// ProcessPendingModelInvocationLogs processes every hour from the checkpoint
+// watermark up to, but not including, end. Objects already processed with the same
+// ETag are skipped, so late or changed objects are picked up by the next run. Without
+// a previous checkpoint only the hour before end is processed.
+func (p *Processor) ProcessPendingModelInvocationLogs(accountID, region, modelInvocationLogsInputBucketPrefix string, end time.Time) (*RunReport, error) {
+	if p.checkpointStore == nil {
+		return nil, errors.New("a checkpoint store is required to process pending logs")
+	}
+
+	if err := p.loadCheckpoint(); err != nil {
+		return nil, err
+	}
+
+	start := end.Add(-time.Hour)
+	if !p.checkpoint.Watermark.IsZero() && p.checkpoint.Watermark.Add(-checkpointLookback).Before(start) {
+		start = p.checkpoint.Watermark.Add(-checkpointLookback)
 	}
 
+	report := newRunReport()
+	err := p.processRange(report, accountID, region, modelInvocationLogsInputBucketPrefix, start, end)
+	return report, p.finishRun(report, err)
+}
+
+// ProcessModelInvocationLogObjects processes the given source objects, for example
+// those referenced by S3 event notifications. Objects are recorded in the checkpoint
+// like those of scheduled runs, so neither processes an object the other already did.
+// Rollups are left to scheduled runs, which would otherwise read back the whole hour
+// on every event: with a checkpoint the objects are marked as pending a rollup of
+// their hour, which the next scheduled run writes even though it skips them. Failed
+// objects are listed in the report's failures.
+func (p *Processor) ProcessModelInvocationLogObjects(objects []storage.ObjectInfo) (*RunReport, error) {
+	if err := p.loadCheckpoint(); err != nil {
+		return nil, err
+	}
+
+	report := newRunReport()
+	report.ObjectsListed = len(objects)
+
 	workerPool := make(chan struct{}, 10)
 
+	var mu sync.Mutex
 	var wg sync.WaitGroup
-	for _, obj := range s3Objects {
+	for _, obj := range objects {
+		if p.checkpoint != nil && p.checkpoint.isProcessed(obj) {
+			report.ObjectsSkipped++
+			continue
+		}
+
 		wg.Add(1)
 		workerPool <- struct{}{}
 
-		go func(obj *s3.Object) {
+		go func(obj storage.ObjectInfo) {
 			defer wg.Done()
 			defer func() { <-workerPool }()
 
-			processedLogs, err := p.ProcessModelInvocationLogS3Object(*obj.Key, p.processLog)
-			if err != nil {
-				log.Printf("Error processing S3 object: %s, error:%v\n", *obj.Key, err)
-				return
+			// Batch inference output has no hour and is never listed by scheduled runs
+			var hour time.Time
+			if sourceKey, err := output.ParseSourceKey(obj.Key); err == nil {
+				hour = sourceKey.Hour
 			}
-
-			err = p.uploadS3Object(*obj.Key, processedLogs)
-			if err != nil {
-				log.Printf("Error uploading S3 object: %s, error:%v\n", *obj.Key, err)
-				return
+			counts, err := p.processObject(obj, hour)
+			if err == nil && p.checkpoint != nil && !hour.IsZero() {
+				p.checkpoint.markRollupPending(obj.Key)
 			}
+
+			mu.Lock()
+			defer mu.Unlock()
+			report.addObject(obj.Key, counts, err)
 		}(obj)
 	}
 
 	wg.Wait()
 
-	return nil
+	return report, p.finishRun(report, nil)
 }
 
-func (p *Processor) ProcessModelInvocationLogS3Object(sourceKey string, logProcessorFunc func([]byte) ([]byte, error)) ([]byte, error) {
-	result, err := p.s3ClientRead.GetObject(&s3.GetObjectInput{
-		Bucket: aws.String(p.modelInvocationLogsInputBucket),
-		Key:    aws.String(sourceKey),
-	})
+// finishRun saves the checkpoint and the run report, and turns object failures into
+// an error once both are written.
+func (p *Processor) finishRun(report *RunReport, err error) error {
+	if saveErr := p.saveCheckpoint(); saveErr != nil && err == nil {
+		err = saveErr
+	}
+	if writeErr := p.writeRunReport(report); writeErr != nil && err == nil {
+		err = writeErr
+	}
 	if err != nil {
-		return nil, fmt.Errorf("unable to get object %q from bucket %q, %v", sourceKey, p.modelInvocationLogsInputBucket, err)
+		return err
+	}
+	return report.Err()
+}
+
+func (p *Processor) processRange(report *RunReport, accountID, region, modelInvocationLogsInputBucketPrefix string, start, end time.Time) error {
+	var hours []time.Time
+	for hour := start.UTC().Truncate(time.Hour); hour.Before(end); hour = hour.Add(time.Hour) {
+		hours = append(hours, hour)
+	}
+
+	summaries := make([]*HourSummary, len(hours))
+	errs := make([]error, len(hours))
+	workerPool := make(chan struct{}, hourWorkers)
+
+	var wg sync.WaitGroup
+	for i, hour := range hours {
+		wg.Add(1)
+		workerPool <- struct{}{}
+
+		go func(i int, hour time.Time) {
+			defer wg.Done()
+			defer func() { <-workerPool }()
+
+			summaries[i], errs[i] = p.ProcessModelInvocationLogs(accountID, region, modelInvocationLogsInputBucketPrefix, hour.Year(), int(hour.Month()), hour.Day(), hour.Hour())
+		}(i, hour)
+	}
+
+	wg.Wait()
+
+	for _, summary := range summaries {
+		report.addHour(summary)
 	}
-	defer result.Body.Close()
 
-	var processedLog bytes.Buffer
-	scanner := bufio.NewScanner(result.Body)
-	for scanner.Scan() {
-		line := scanner.Bytes()
-		logMetadata, err := logProcessorFunc(line)
+	for i, err := range errs {
 		if err != nil {
-			log.Printf("Error processing log: %v\n", err)
-			continue
+			return fmt.Errorf("error processing hour %s: %w", hours[i].Format(time.RFC3339), err)
 		}
-		processedLog.Write(logMetadata)
-		processedLog.WriteByte('\n')
 	}
 
-	if err := scanner.Err(); err != nil {
-		return nil, fmt.Errorf("error reading from S3 object: %v", err)
+	// Only a run without failures moves the watermark, so failed objects are retried
+	if report.ObjectsFailed == 0 && p.checkpoint != nil && len(hours) > 0 {
+		p.checkpoint.advanceWatermark(hours[len(hours)-1])
 	}
 
-	return processedLog.Bytes(), nil
+	return nil
 }
 
-func (p *Processor) listObjectsInDateRange(accountID, region, modelInvocationLogsInputBucketPrefix string, year, month, day, hour int) ([]*s3.Object, error) {
-	var datePrefix string
-	if modelInvocationLogsInputBucketPrefix == "" {
-		datePrefix = fmt.Sprintf("AWSLogs/%s/BedrockModelInvocationLogs/%s/%d/%02d/%02d/%02d", accountID, region, year, month, day, hour)
-	} else {
-		datePrefix = fmt.Sprintf("%s/AWSLogs/%s/BedrockModelInvocationLogs/%s/%d/%02d/%02d/%02d", modelInvocationLogsInputBucketPrefix, accountID, region, year, month, day, hour)
+func (p *Processor) loadCheckpoint() error {
+	if p.checkpointStore == nil {
+		return nil
 	}
 
-	paginator := func(input *s3.ListObjectsInput) (*s3.ListObjectsOutput, error) {
-		return p.s3ClientRead.ListObjects(input)
+	checkpoint, _, err := loadCheckpoint(p.checkpointStore)
+	if err != nil {
+		return fmt.Errorf("unable to load checkpoint, %v", err)
 	}
+	p.checkpoint = checkpoint
+	return nil
+}
 
-	params := &s3.ListObjectsInput{
-		Bucket: aws.String(p.modelInvocationLogsInputBucket),
-		Prefix: aws.String(datePrefix),
+func (p *Processor) saveCheckpoint() error {
+	if p.checkpoint == nil {
+		return nil
 	}
 
-	var objects []*s3.Object
-	for {
-		output, err := paginator(params)
-		if err != nil {
-			return nil, fmt.Errorf("error listing objects: %w", err)
-		}
+	if err := p.checkpoint.save(p.checkpointStore); err != nil {
+		return fmt.Errorf("unable to save checkpoint, %v", err)
+	}
+	return nil
+}
+
+func (p *Processor) ProcessModelInvocationLogs(accountID, region, modelInvocationLogsInputBucketPrefix string, year, month, day, hour int) (*HourSummary, error) {
+	summary := &HourSummary{Hour: time.Date(year, time.Month(month), day, hour, 0, 0, 0, time.UTC)}
 
-		for _, obj := range output.Contents {
-			objects = append(objects, obj)
+	logObjects, err := p.listObjectsInDateRange(accountID, region, modelInvocationLogsInputBucketPrefix, year, month, day, hour)
+	if err != nil {
+		return summary, err
+	}
+	summary.ObjectsListed = len(logObjects)
+
+	workerPool := make(chan struct{}, 10)
+
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	var rollupPendingKeys []string
+	for _, obj := range logObjects {
+		if p.checkpoint != nil && p.checkpoint.isProcessed(obj) {
+			summary.ObjectsSkipped++
+			if p.checkpoint.isRollupPending(obj.Key) {
+				rollupPendingKeys = append(rollupPendingKeys, obj.Key)
+			}
+			continue
 		}
 
-		if !*output.IsTruncated {
-			break
+		wg.Add(1)
+		workerPool <- struct{}{}
+
+		go func(obj storage.ObjectInfo) {
+			defer wg.Done()
+			defer func() { <-workerPool }()
+
+			counts, err := p.processObject(obj, summary.Hour)
+
+			mu.Lock()
+			defer mu.Unlock()
+			summary.Counts.add(counts)
+			if err != nil {
+				summary.Failures = append(summary.Failures, ObjectFailure{Key: obj.Key, Error: err.Error()})
+			}
+		}(obj)
+	}
+
+	wg.Wait()
+
+	// The rollups only change when metadata of the hour was written, by this run or an
+	// event invocation, or when an object failed and may have left the hour incomplete.
+	// Hours without objects are rolled up the first time they are processed, for the
+	// cost of idle provisioned models.
+	processed := summary.ObjectsSucceeded > 0 || summary.ObjectsFailed > 0 || len(rollupPendingKeys) > 0
+	if !processed && (summary.ObjectsListed > 0 || (p.checkpoint != nil && !p.checkpoint.isAfterWatermark(summary.Hour))) {
+		return summary, nil
+	}
+
+	h := rollupHour{inputPrefix: modelInvocationLogsInputBucketPrefix, accountID: accountID, region: region, hour: summary.Hour}
+	if err := p.rollup(h); err != nil {
+		return summary, fmt.Errorf("unable to roll up hour, %v", err)
+	}
+	if p.checkpoint != nil {
+		p.checkpoint.markRolledUp(rollupPendingKeys)
+	}
+
+	return summary, nil
+}
+
+// processObject generates and uploads the metadata for a single source object, and
+// records it in the checkpoint once uploaded. Reading, transforming, encoding and
+// uploading run concurrently through a pipe, so the object is never held in memory.
+func (p *Processor) processObject(obj storage.ObjectInfo, hour time.Time) (Counts, error) {
+	outputKey, logProcessorFunc, err := p.objectProcessor(obj.Key)
+	if err != nil {
+		log.Printf("Error processing object: %s, error:%v\n", obj.Key, err)
+		return Counts{ObjectsFailed: 1}, err
+	}
+
+	pipeReader, pipeWriter := io.Pipe()
+
+	type result struct {
+		counts Counts
+		err    error
+	}
+	processed := make(chan result, 1)
+
+	go func() {
+		encoder := output.NewEncoder(p.output.Format, pipeWriter)
+		counts, err := p.ProcessModelInvocationLogObject(obj.Key, encoder, logProcessorFunc)
+		if err == nil {
+			err = encoder.Close()
 		}
+		// A processing error aborts the upload instead of completing a partial object
+		pipeWriter.CloseWithError(err)
+		processed <- result{counts: counts, err: err}
+	}()
+
+	format := p.output.Format
+	uploadErr := p.metadataSink.WriteObject(outputKey, pipeReader, format.ContentType(), format.ContentEncoding())
+	// Unblock the writer if the upload stopped reading early
+	pipeReader.CloseWithError(uploadErr)
 
-		params.Marker = output.NextMarker
+	res := <-processed
+	counts, err := res.counts, res.err
+	if err != nil {
+		log.Printf("Error processing object: %s, error:%v\n", obj.Key, err)
+	} else if uploadErr != nil {
+		err = uploadErr
+		log.Printf("Error uploading object: %s, error:%v\n", obj.Key, err)
 	}
 
-	return objects, nil
+	if err != nil {
+		counts.ObjectsFailed = 1
+		return counts, err
+	}
+
+	counts.ObjectsSucceeded = 1
+	if p.checkpoint != nil {
+		p.checkpoint.markProcessed(obj, hour)
+	}
+	return counts, nil
 }
 
-func (p *Processor) uploadS3Object(objectKey string, body []byte) error {
+// objectProcessor returns the metadata object key and the line processor of a source
+// object, which is either a model invocation log or a batch inference job output
+func (p *Processor) objectProcessor(sourceKey string) (string, func([]byte) (*model.InvocationLogMetadata, error), error) {
+	jobID, ok := output.BatchJobID(sourceKey)
+	if !ok {
+		outputKey, err := p.output.ObjectKey(sourceKey)
+		return outputKey, p.processLog, err
+	}
 
-	gzippedContent, err := p.gzipContent(body)
+	job, err := p.modelInvocation.BatchJob(jobID)
 	if err != nil {
-		return err
+		return "", nil, fmt.Errorf("unable to get batch inference job %s, %v", jobID, err)
 	}
-	_, err = p.s3ClientWrite.PutObject(&s3.PutObjectInput{
-		Bucket:          aws.String(p.metadataLogsOutputBucket),
-		Key:             aws.String(objectKey),
-		Body:            bytes.NewReader(gzippedContent),
-		ContentEncoding: aws.String("gzip"),
-		ContentType:     aws.String("application/json"),
-	})
+	accountID, region, err := job.Location()
 	if err != nil {
-		return fmt.Errorf("unable to upload object %q to bucket %q, %v", objectKey, p.metadataLogsOutputBucket, err)
+		return "", nil, err
 	}
-	return nil
+	outputKey := p.output.BatchObjectKey(sourceKey, accountID, region, job.Time())
+
+	return outputKey, func(line []byte) (*model.InvocationLogMetadata, error) {
+		return p.processBatchRecord(jobID, line)
+	}, nil
 }
 
-func (p *Processor) processLog(line []byte) ([]byte, error) {
-	var modelInvocationLog model.InvocationLog
+// ProcessModelInvocationLogObject reads a model invocation log object and writes the
+// metadata of each log line to encoder. The encoder is not closed.
+func (p *Processor) ProcessModelInvocationLogObject(sourceKey string, encoder output.Encoder, logProcessorFunc func([]byte) (*model.InvocationLogMetadata, error)) (Counts, error) {
+	var counts Counts
 
-	err := json.Unmarshal(line, &modelInvocationLog)
+	body, err := p.logSource.OpenObject(sourceKey)
 	if err != nil {
-		return nil, err
+		return counts, err
 	}
-	metadata, err := p.modelInvocation.GenerateModelInvocationLogMetadata(&modelInvocationLog)
+	defer body.Close()
+
+	logReader, err := newLogReader(body)
 	if err != nil {
-		return nil, err
+		return counts, fmt.Errorf("unable to decompress object %q, %v", sourceKey, err)
+	}
+
+	for {
+		// ReadBytes has no size limit, unlike bufio.Scanner, so records with large
+		// prompts or images in their body are read whole
+		line, readErr := logReader.ReadBytes('\n')
+		if readErr != nil && readErr != io.EOF {
+			return counts, fmt.Errorf("error reading from object: %v", readErr)
+		}
+
+		if line = bytes.TrimSpace(line); len(line) > 0 {
+			metadata, err := logProcessorFunc(line)
+			if err != nil {
+				log.Printf("Error processing log: %v\n", err)
+				counts.LinesSkipped++
+			} else {
+				if err := encoder.Encode(metadata); err != nil {
+					return counts, err
+				}
+
+				counts.LinesParsed++
+				counts.TotalCostUSD += metadata.TotalCostUSD()
+			}
+		}
+
+		if readErr == io.EOF {
+			break
+		}
 	}
 
-	transformedContent, err := json.Marshal(metadata)
+	return counts, nil
+}
+
+func (p *Processor) listObjectsInDateRange(accountID, region, modelInvocationLogsInputBucketPrefix string, year, month, day, hour int) ([]storage.ObjectInfo, error) {
+	var datePrefix string
+	if modelInvocationLogsInputBucketPrefix == "" {
+		datePrefix = fmt.Sprintf("AWSLogs/%s/BedrockModelInvocationLogs/%s/%d/%02d/%02d/%02d", accountID, region, year, month, day, hour)
+	} else {
+		datePrefix = fmt.Sprintf("%s/AWSLogs/%s/BedrockModelInvocationLogs/%s/%d/%02d/%02d/%02d", modelInvocationLogsInputBucketPrefix, accountID, region, year, month, day, hour)
+	}
+
+	return p.logSource.ListObjects(datePrefix)
+}
+
+func (p *Processor) processLog(line []byte) (*model.InvocationLogMetadata, error) {
+	var modelInvocationLog model.InvocationLog
+
+	err := json.Unmarshal(line, &modelInvocationLog)
 	if err != nil {
 		return nil, err
 	}
-	return transformedContent, nil
+	return p.modelInvocation.GenerateModelInvocationLogMetadata(&modelInvocationLog)
 }
 
-func (p *Processor) gzipContent(input []byte) ([]byte, error) {
-	var buf bytes.Buffer
-	gz := gzip.NewWriter(&buf)
-	_, err := gz.Write(input)
+func (p *Processor) processBatchRecord(jobID string, line []byte) (*model.InvocationLogMetadata, error) {
+	var record model.BatchInferenceRecord
+
+	err := json.Unmarshal(line, &record)
 	if err != nil {
 		return nil, err
 	}
-	if err := gz.Close(); err != nil {
+	return p.modelInvocation.GenerateBatchInferenceRecordMetadata(jobID, &record)
+}
+
+// newLogReader returns a reader over the decompressed content of a model invocation
+// log object. Bedrock delivers logs as .json.gz, but depending on the object's
+// Content-Encoding the HTTP transport may already have decoded the body, so the key
+// suffix and headers are not trusted and the gzip magic bytes are sniffed instead.
+func newLogReader(body io.Reader) (*bufio.Reader, error) {
+	bufferedBody := bufio.NewReader(body)
+
+	magic, err := bufferedBody.Peek(len(gzipMagic))
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+
+	if !bytes.Equal(magic, gzipMagic) {
+		return bufferedBody, nil
+	}
+
+	gz, err := gzip.NewReader(bufferedBody)
+	if err != nil {
 		return nil, err
 	}
-	return buf.Bytes(), nil
+	return bufio.NewReader(gz), nil
 }
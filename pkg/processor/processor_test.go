@@ -0,0 +1,730 @@
+package processor
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"crypto/md5"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/iam"
+	"github.com/greenscale-ai/amazon-bedrock-metadata/pkg/model"
+	"github.com/greenscale-ai/amazon-bedrock-metadata/pkg/output"
+	"github.com/greenscale-ai/amazon-bedrock-metadata/pkg/storage"
+	"github.com/parquet-go/parquet-go"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// memoryStore is an in-memory LogSource and MetadataSink. With quoteETags it lists
+// ETags in quotes, like S3.
+type memoryStore struct {
+	mu         sync.Mutex
+	objects    map[string][]byte
+	quoteETags bool
+}
+
+func newMemoryStore() *memoryStore {
+	return &memoryStore{objects: make(map[string][]byte)}
+}
+
+func (m *memoryStore) ListObjects(prefix string) ([]storage.ObjectInfo, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var objects []storage.ObjectInfo
+	for key, body := range m.objects {
+		if strings.HasPrefix(key, prefix) {
+			etag := fmt.Sprintf("%x", md5.Sum(body))
+			if m.quoteETags {
+				etag = `"` + etag + `"`
+			}
+			objects = append(objects, storage.ObjectInfo{Key: key, ETag: etag, Size: int64(len(body))})
+		}
+	}
+	sort.Slice(objects, func(i, j int) bool { return objects[i].Key < objects[j].Key })
+	return objects, nil
+}
+
+func (m *memoryStore) OpenObject(key string) (io.ReadCloser, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	body, ok := m.objects[key]
+	if !ok {
+		return nil, fmt.Errorf("object %q not found", key)
+	}
+	return io.NopCloser(bytes.NewReader(body)), nil
+}
+
+func (m *memoryStore) WriteObject(key string, body io.Reader, contentType, contentEncoding string) error {
+	content, err := io.ReadAll(body)
+	if err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.objects[key] = content
+	return nil
+}
+
+// memoryCheckpointStore is an in-memory CheckpointStore, versioned by a save counter.
+// beforeSave, when set, runs before each save, as a concurrent save would.
+type memoryCheckpointStore struct {
+	body       []byte
+	saves      int
+	beforeSave func()
+}
+
+func (m *memoryCheckpointStore) LoadCheckpoint() ([]byte, string, error) {
+	return m.body, m.version(), nil
+}
+
+func (m *memoryCheckpointStore) SaveCheckpoint(body []byte, version string) error {
+	if m.beforeSave != nil {
+		m.beforeSave()
+	}
+	if version != m.version() {
+		return storage.ErrCheckpointConflict
+	}
+	m.body = body
+	m.saves++
+	return nil
+}
+
+func (m *memoryCheckpointStore) version() string {
+	if m.body == nil {
+		return ""
+	}
+	return strconv.Itoa(m.saves)
+}
+
+// newTestMetadataGenerator creates a metadata generator pricing the models of
+// models.json, with the given options
+func newTestMetadataGenerator(t *testing.T, options model.GeneratorOptions) *model.MetadataGenerator {
+	t.Helper()
+
+	modelsPriceDetails, err := os.ReadFile("../../models.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	modelCostEstimator, err := model.NewCostEstimator(modelsPriceDetails)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	iamSess, err := session.NewSession(&aws.Config{
+		Region: aws.String("us-east-1"),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	modelCarbonFootprint := model.NewCarbonFootprintEstimator(400, 768000, 450)
+	identityTagsBuilder := model.NewIdentityTagsBuilder(iam.New(iamSess))
+
+	return model.NewMetadataGenerator(modelCostEstimator, modelCarbonFootprint, identityTagsBuilder, options)
+}
+
+func readMetadata(t *testing.T, body []byte) []model.InvocationLogMetadata {
+	gz, err := gzip.NewReader(bytes.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var metadata []model.InvocationLogMetadata
+	scanner := bufio.NewScanner(gz)
+	for scanner.Scan() {
+		var invocationLogMetadata model.InvocationLogMetadata
+		if err := json.Unmarshal(scanner.Bytes(), &invocationLogMetadata); err != nil {
+			t.Fatal(err)
+		}
+		metadata = append(metadata, invocationLogMetadata)
+	}
+	if err := scanner.Err(); err != nil {
+		t.Fatal(err)
+	}
+	return metadata
+}
+
+func TestProcessModelInvocationLogs(t *testing.T) {
+	plainLogs, err := os.ReadFile("../model/test_data/input_invocation_logs.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	gzippedLogs, err := os.ReadFile("../model/test_data/input_invocation_logs.json.gz")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	hourPrefix := "AWSLogs/893487256304/BedrockModelInvocationLogs/us-west-2/2024/03/05/20/"
+	logStore := newMemoryStore()
+	logStore.objects[hourPrefix+"plain.json"] = plainLogs
+	logStore.objects[hourPrefix+"gzipped.json.gz"] = gzippedLogs
+	logStore.objects["AWSLogs/893487256304/BedrockModelInvocationLogs/us-west-2/2024/03/05/21/other.json.gz"] = gzippedLogs
+
+	metadataStore := newMemoryStore()
+
+	modelLogsProcessor := NewProcessor(logStore, metadataStore, nil, newTestMetadataGenerator(t, model.GeneratorOptions{}), output.Options{})
+	summary, err := modelLogsProcessor.ProcessModelInvocationLogs("893487256304", "us-west-2", "", 2024, 3, 5, 20)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if summary.ObjectsListed != 2 || summary.ObjectsSucceeded != 2 || summary.LinesParsed != 4 || summary.LinesSkipped != 0 {
+		t.Errorf("got %+v, wanted 2 objects and 4 lines", summary.Counts)
+	}
+
+	if fmt.Sprintf("%f", summary.TotalCostUSD) != "0.000621" {
+		t.Errorf("got %f, wanted %s", summary.TotalCostUSD, "0.000621")
+	}
+
+	if len(metadataStore.objects) != 2 {
+		t.Fatalf("got %d objects, wanted %d", len(metadataStore.objects), 2)
+	}
+
+	for _, key := range []string{hourPrefix + "plain.json", hourPrefix + "gzipped.json.gz"} {
+		body, ok := metadataStore.objects[key]
+		if !ok {
+			t.Fatalf("missing metadata object %q", key)
+		}
+
+		metadata := readMetadata(t, body)
+		if len(metadata) != 2 {
+			t.Fatalf("%s: got %d records, wanted %d", key, len(metadata), 2)
+		}
+
+		if metadata[0].ModelID != "meta.llama2-13b-chat-v1" {
+			t.Errorf("%s: got %q, wanted %q", key, metadata[0].ModelID, "meta.llama2-13b-chat-v1")
+		}
+
+		if metadata[1].InvocationLatency != 8129 {
+			t.Errorf("%s: got %d, wanted %d", key, metadata[1].InvocationLatency, 8129)
+		}
+	}
+}
+
+func TestProcessModelInvocationLogsParquet(t *testing.T) {
+	gzippedLogs, err := os.ReadFile("../model/test_data/input_invocation_logs.json.gz")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	hourPrefix := "AWSLogs/893487256304/BedrockModelInvocationLogs/us-west-2/2024/03/05/20/"
+	logStore := newMemoryStore()
+	logStore.objects[hourPrefix+"logs.json.gz"] = gzippedLogs
+
+	metadataStore := newMemoryStore()
+
+	modelLogsProcessor := NewProcessor(logStore, metadataStore, nil, newTestMetadataGenerator(t, model.GeneratorOptions{}), output.Options{Format: output.FormatParquet})
+	if _, err := modelLogsProcessor.ProcessModelInvocationLogs("893487256304", "us-west-2", "", 2024, 3, 5, 20); err != nil {
+		t.Fatal(err)
+	}
+
+	body, ok := metadataStore.objects[hourPrefix+"logs.parquet"]
+	if !ok {
+		t.Fatalf("missing metadata object %q", hourPrefix+"logs.parquet")
+	}
+
+	metadata, err := parquet.Read[model.InvocationLogMetadata](bytes.NewReader(body), int64(len(body)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(metadata) != 2 {
+		t.Fatalf("got %d records, wanted %d", len(metadata), 2)
+	}
+
+	if metadata[0].ModelID != "meta.llama2-13b-chat-v1" {
+		t.Errorf("got %q, wanted %q", metadata[0].ModelID, "meta.llama2-13b-chat-v1")
+	}
+}
+
+func TestProcessModelInvocationLogsHiveLayout(t *testing.T) {
+	gzippedLogs, err := os.ReadFile("../model/test_data/input_invocation_logs.json.gz")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	logStore := newMemoryStore()
+	logStore.objects["logs/AWSLogs/893487256304/BedrockModelInvocationLogs/us-west-2/2024/03/05/20/logs.json.gz"] = gzippedLogs
+
+	metadataStore := newMemoryStore()
+
+	outputOptions := output.Options{Layout: output.LayoutHive, Prefix: "metadata"}
+	modelLogsProcessor := NewProcessor(logStore, metadataStore, nil, newTestMetadataGenerator(t, model.GeneratorOptions{}), outputOptions)
+	if _, err := modelLogsProcessor.ProcessModelInvocationLogs("893487256304", "us-west-2", "logs", 2024, 3, 5, 20); err != nil {
+		t.Fatal(err)
+	}
+
+	key := "metadata/account=893487256304/region=us-west-2/year=2024/month=03/day=05/hour=20/logs.json.gz"
+	body, ok := metadataStore.objects[key]
+	if !ok {
+		t.Fatalf("missing metadata object %q", key)
+	}
+
+	if metadata := readMetadata(t, body); len(metadata) != 2 {
+		t.Errorf("got %d records, wanted %d", len(metadata), 2)
+	}
+}
+
+func TestProcessModelInvocationLogsInRange(t *testing.T) {
+	gzippedLogs, err := os.ReadFile("../model/test_data/input_invocation_logs.json.gz")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	logStore := newMemoryStore()
+	for _, hour := range []string{"2024/03/05/22", "2024/03/05/23", "2024/03/06/00", "2024/03/06/01"} {
+		logStore.objects["AWSLogs/893487256304/BedrockModelInvocationLogs/us-west-2/"+hour+"/logs.json.gz"] = gzippedLogs
+	}
+	logStore.objects["AWSLogs/893487256304/BedrockModelInvocationLogs/us-west-2/2024/03/05/22/invalid.json"] = []byte("not json\n")
+
+	metadataStore := newMemoryStore()
+
+	modelLogsProcessor := NewProcessor(logStore, metadataStore, nil, newTestMetadataGenerator(t, model.GeneratorOptions{}), output.Options{})
+	start := time.Date(2024, 3, 5, 22, 30, 0, 0, time.UTC)
+	end := time.Date(2024, 3, 6, 1, 0, 0, 0, time.UTC)
+	report, err := modelLogsProcessor.ProcessModelInvocationLogsInRange("893487256304", "us-west-2", "", start, end)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	summaries := report.Hours
+	if len(summaries) != 3 {
+		t.Fatalf("got %d hours, wanted %d", len(summaries), 3)
+	}
+
+	if !summaries[0].Hour.Equal(time.Date(2024, 3, 5, 22, 0, 0, 0, time.UTC)) {
+		t.Errorf("got %s, wanted %s", summaries[0].Hour, "2024-03-05T22:00:00Z")
+	}
+
+	if summaries[0].ObjectsListed != 2 || summaries[0].LinesParsed != 2 || summaries[0].LinesSkipped != 1 {
+		t.Errorf("got %+v, wanted 2 objects, 2 parsed and 1 skipped line", summaries[0].Counts)
+	}
+
+	if summaries[2].ObjectsListed != 1 || summaries[2].LinesParsed != 2 {
+		t.Errorf("got %+v, wanted 1 object and 2 lines", summaries[2].Counts)
+	}
+
+	if report.ObjectsListed != 4 || report.ObjectsSucceeded != 4 || report.LinesParsed != 6 {
+		t.Errorf("got %+v, wanted 4 objects and 6 lines", report.Counts)
+	}
+
+	// hour 01 is excluded by the end of the range, and the run report is written
+	var metadataKeys, reportKeys int
+	for key := range metadataStore.objects {
+		if strings.HasPrefix(key, reportKeyPrefix+"/") {
+			reportKeys++
+		} else {
+			metadataKeys++
+		}
+	}
+	if metadataKeys != 4 || reportKeys != 1 {
+		t.Errorf("got %d metadata objects and %d reports, wanted 4 and 1", metadataKeys, reportKeys)
+	}
+}
+
+func TestProcessPendingModelInvocationLogs(t *testing.T) {
+	gzippedLogs, err := os.ReadFile("../model/test_data/input_invocation_logs.json.gz")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	hourPrefix := "AWSLogs/893487256304/BedrockModelInvocationLogs/us-west-2/2024/03/05/"
+	logStore := newMemoryStore()
+	logStore.objects[hourPrefix+"20/a.json.gz"] = gzippedLogs
+
+	metadataStore := newMemoryStore()
+	checkpointStore := &memoryCheckpointStore{}
+	metadataGenerator := newTestMetadataGenerator(t, model.GeneratorOptions{})
+
+	run := func(end time.Time) *RunReport {
+		modelLogsProcessor := NewProcessor(logStore, metadataStore, checkpointStore, metadataGenerator, output.Options{})
+		report, _ := modelLogsProcessor.ProcessPendingModelInvocationLogs("893487256304", "us-west-2", "", end)
+		return report
+	}
+
+	countObjects := func(report *RunReport) (processed, skipped int) {
+		return report.ObjectsListed - report.ObjectsSkipped, report.ObjectsSkipped
+	}
+
+	// Without a checkpoint only the previous hour is processed
+	summaries := run(time.Date(2024, 3, 5, 21, 0, 0, 0, time.UTC))
+	if processed, skipped := countObjects(summaries); len(summaries.Hours) != 1 || processed != 1 || skipped != 0 {
+		t.Fatalf("got %d hours, %d processed and %d skipped objects, wanted 1, 1 and 0", len(summaries.Hours), processed, skipped)
+	}
+
+	// A late object in the processed hour and a new object in the next hour are picked up
+	logStore.objects[hourPrefix+"20/b.json.gz"] = gzippedLogs
+	logStore.objects[hourPrefix+"21/c.json.gz"] = gzippedLogs
+	summaries = run(time.Date(2024, 3, 5, 22, 0, 0, 0, time.UTC))
+	if processed, skipped := countObjects(summaries); processed != 2 || skipped != 1 {
+		t.Errorf("got %d processed and %d skipped objects, wanted 2 and 1", processed, skipped)
+	}
+
+	// Changed objects are processed again
+	logStore.objects[hourPrefix+"21/c.json.gz"] = append([]byte(nil), gzippedLogs...)[:len(gzippedLogs)-1]
+	summaries = run(time.Date(2024, 3, 5, 22, 0, 0, 0, time.UTC))
+	if processed, skipped := countObjects(summaries); processed != 1 || skipped != 2 {
+		t.Errorf("got %d processed and %d skipped objects, wanted 1 and 2", processed, skipped)
+	}
+
+	var checkpoint Checkpoint
+	if err := json.Unmarshal(checkpointStore.body, &checkpoint); err != nil {
+		t.Fatal(err)
+	}
+
+	// The truncated object failed, so the watermark stays at the previous run
+	if !checkpoint.Watermark.Equal(time.Date(2024, 3, 5, 21, 0, 0, 0, time.UTC)) {
+		t.Errorf("got %s, wanted %s", checkpoint.Watermark, "2024-03-05T21:00:00Z")
+	}
+
+	// The failed object is retried by the next run
+	summaries = run(time.Date(2024, 3, 5, 22, 0, 0, 0, time.UTC))
+	if processed, skipped := countObjects(summaries); processed != 1 || skipped != 2 {
+		t.Errorf("got %d processed and %d skipped objects, wanted 1 and 2", processed, skipped)
+	}
+}
+
+func TestProcessModelInvocationLogObjects(t *testing.T) {
+	gzippedLogs, err := os.ReadFile("../model/test_data/input_invocation_logs.json.gz")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	hourPrefix := "AWSLogs/893487256304/BedrockModelInvocationLogs/us-west-2/2024/03/05/20/"
+	logStore := newMemoryStore()
+	logStore.objects[hourPrefix+"a.json.gz"] = gzippedLogs
+	logStore.objects[hourPrefix+"b.json.gz"] = gzippedLogs
+
+	metadataStore := newMemoryStore()
+
+	modelLogsProcessor := NewProcessor(logStore, metadataStore, nil, newTestMetadataGenerator(t, model.GeneratorOptions{}), output.Options{})
+	report, err := modelLogsProcessor.ProcessModelInvocationLogObjects([]storage.ObjectInfo{{Key: hourPrefix + "a.json.gz"}, {Key: hourPrefix + "missing.json.gz"}})
+	var objectsFailed *ObjectsFailedError
+	if !errors.As(err, &objectsFailed) || objectsFailed.Failed != 1 {
+		t.Errorf("got %v, wanted an error for the failed object", err)
+	}
+
+	if len(report.Failures) != 1 || report.Failures[0].Key != hourPrefix+"missing.json.gz" {
+		t.Errorf("got %v, wanted a failure for %q", report.Failures, hourPrefix+"missing.json.gz")
+	}
+
+	if report.ObjectsSucceeded != 1 || report.ObjectsFailed != 1 {
+		t.Errorf("got %+v, wanted 1 succeeded and 1 failed object", report.Counts)
+	}
+
+	if _, ok := metadataStore.objects[hourPrefix+"a.json.gz"]; !ok {
+		t.Errorf("missing metadata object %q", hourPrefix+"a.json.gz")
+	}
+
+	reports, _ := metadataStore.ListObjects(reportKeyPrefix + "/")
+	if len(reports) != 1 {
+		t.Fatalf("got %d reports, wanted %d", len(reports), 1)
+	}
+
+	var writtenReport RunReport
+	if err := json.Unmarshal(metadataStore.objects[reports[0].Key], &writtenReport); err != nil {
+		t.Fatal(err)
+	}
+
+	if writtenReport.ObjectsFailed != 1 || len(writtenReport.Failures) != 1 || writtenReport.FinishedAt.IsZero() {
+		t.Errorf("got %+v, wanted the failed object in the written report", writtenReport)
+	}
+}
+
+func TestProcessModelInvocationLogObjectsCheckpoint(t *testing.T) {
+	gzippedLogs, err := os.ReadFile("../model/test_data/input_invocation_logs.json.gz")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	hourPrefix := "AWSLogs/893487256304/BedrockModelInvocationLogs/us-west-2/2024/03/05/20/"
+	logStore := newMemoryStore()
+	logStore.objects[hourPrefix+"a.json.gz"] = gzippedLogs
+	logStore.objects[hourPrefix+"b.json.gz"] = gzippedLogs
+	listed, _ := logStore.ListObjects(hourPrefix)
+
+	metadataStore := newMemoryStore()
+	checkpointStore := &memoryCheckpointStore{}
+	metadataGenerator := newTestMetadataGenerator(t, model.GeneratorOptions{})
+
+	// S3 events carry the ETag without the quotes of S3 listings
+	eventObject := storage.ObjectInfo{Key: listed[0].Key, ETag: listed[0].ETag}
+	modelLogsProcessor := NewProcessor(logStore, metadataStore, checkpointStore, metadataGenerator, output.Options{})
+	if _, err := modelLogsProcessor.ProcessModelInvocationLogObjects([]storage.ObjectInfo{eventObject}); err != nil {
+		t.Fatal(err)
+	}
+
+	// A redelivered event is skipped
+	modelLogsProcessor = NewProcessor(logStore, metadataStore, checkpointStore, metadataGenerator, output.Options{})
+	report, err := modelLogsProcessor.ProcessModelInvocationLogObjects([]storage.ObjectInfo{eventObject})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.ObjectsSkipped != 1 || report.ObjectsSucceeded != 0 {
+		t.Errorf("got %+v, wanted 1 skipped object", report.Counts)
+	}
+
+	// The scheduled run only processes the object without an event
+	logStore.quoteETags = true
+	modelLogsProcessor = NewProcessor(logStore, metadataStore, checkpointStore, metadataGenerator, output.Options{})
+	start := time.Date(2024, 3, 5, 20, 0, 0, 0, time.UTC)
+	report, err = modelLogsProcessor.ProcessModelInvocationLogsInRange("893487256304", "us-west-2", "", start, start.Add(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.ObjectsSkipped != 1 || report.ObjectsSucceeded != 1 {
+		t.Errorf("got %+v, wanted 1 skipped and 1 succeeded object", report.Counts)
+	}
+}
+
+// failingSink fails uploads after reading part of the body, like an aborted multipart upload
+type failingSink struct{}
+
+func (f failingSink) WriteObject(key string, body io.Reader, contentType, contentEncoding string) error {
+	if _, err := io.ReadFull(body, make([]byte, 16)); err != nil {
+		return err
+	}
+	return errors.New("upload failed")
+}
+
+func TestCheckpointSaveConcurrent(t *testing.T) {
+	hour := time.Date(2024, 3, 5, 20, 0, 0, 0, time.UTC)
+	checkpointStore := &memoryCheckpointStore{}
+
+	// Another invocation saves its object between the load and the save of the first
+	concurrent := true
+	checkpointStore.beforeSave = func() {
+		if !concurrent {
+			return
+		}
+		concurrent = false
+
+		other := &Checkpoint{Objects: make(map[string]CheckpointEntry)}
+		other.markProcessed(storage.ObjectInfo{Key: "b.json.gz", ETag: "b"}, hour)
+		if err := other.save(checkpointStore); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	checkpoint := &Checkpoint{Objects: make(map[string]CheckpointEntry)}
+	checkpoint.markProcessed(storage.ObjectInfo{Key: "a.json.gz", ETag: "a"}, hour)
+	if err := checkpoint.save(checkpointStore); err != nil {
+		t.Fatal(err)
+	}
+
+	saved, _, err := loadCheckpoint(checkpointStore)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, key := range []string{"a.json.gz", "b.json.gz"} {
+		if _, ok := saved.Objects[key]; !ok {
+			t.Errorf("got %v, wanted %q to be kept", saved.Objects, key)
+		}
+	}
+
+	// Saving gives up after repeated conflicts
+	checkpointStore.beforeSave = func() { checkpointStore.saves++ }
+	if err := checkpoint.save(checkpointStore); !errors.Is(err, storage.ErrCheckpointConflict) {
+		t.Errorf("got %v, wanted %v", err, storage.ErrCheckpointConflict)
+	}
+}
+
+func TestProcessModelInvocationLogObjectsUploadFailure(t *testing.T) {
+	gzippedLogs, err := os.ReadFile("../model/test_data/input_invocation_logs.json.gz")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	key := "AWSLogs/893487256304/BedrockModelInvocationLogs/us-west-2/2024/03/05/20/a.json.gz"
+	logStore := newMemoryStore()
+	logStore.objects[key] = gzippedLogs
+
+	modelLogsProcessor := NewProcessor(logStore, failingSink{}, nil, newTestMetadataGenerator(t, model.GeneratorOptions{}), output.Options{})
+	counts, err := modelLogsProcessor.processObject(storage.ObjectInfo{Key: key}, time.Time{})
+	if err == nil || err.Error() != "upload failed" {
+		t.Errorf("got %v, wanted %q", err, "upload failed")
+	}
+
+	if counts.ObjectsFailed != 1 {
+		t.Errorf("got %+v, wanted 1 failed object", counts)
+	}
+}
+
+func TestProcessModelInvocationLogObjectLargeRecord(t *testing.T) {
+	input, err := os.ReadFile("../model/test_data/input_invoke.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var invocationLog map[string]any
+	if err := json.Unmarshal(input, &invocationLog); err != nil {
+		t.Fatal(err)
+	}
+
+	// A multimodal request with a base64 encoded image well beyond bufio.Scanner's 64 KiB limit
+	invocationLog["input"].(map[string]any)["inputBodyJson"] = map[string]any{
+		"image": strings.Repeat("iVBORw0KGgo", 512*1024),
+	}
+	largeRecord, err := json.Marshal(invocationLog)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var smallRecord bytes.Buffer
+	if err := json.Compact(&smallRecord, input); err != nil {
+		t.Fatal(err)
+	}
+
+	var logs bytes.Buffer
+	gz := gzip.NewWriter(&logs)
+	gz.Write(largeRecord)
+	gz.Write([]byte("\n"))
+	gz.Write(smallRecord.Bytes())
+	gz.Close()
+
+	key := "AWSLogs/893487256304/BedrockModelInvocationLogs/us-west-2/2024/03/05/20/large.json.gz"
+	logStore := newMemoryStore()
+	logStore.objects[key] = logs.Bytes()
+
+	modelLogsProcessor := NewProcessor(logStore, newMemoryStore(), nil, newTestMetadataGenerator(t, model.GeneratorOptions{}), output.Options{})
+	var processedLogs bytes.Buffer
+	encoder := output.NewEncoder(output.FormatJSON, &processedLogs)
+	counts, err := modelLogsProcessor.ProcessModelInvocationLogObject(key, encoder, modelLogsProcessor.processLog)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := encoder.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(largeRecord) < 5*1024*1024 {
+		t.Fatalf("got a %d byte record, wanted a multi-megabyte record", len(largeRecord))
+	}
+
+	if counts.LinesParsed != 2 || counts.LinesSkipped != 0 {
+		t.Errorf("got %+v, wanted 2 parsed lines", counts)
+	}
+
+	if metadata := readMetadata(t, processedLogs.Bytes()); len(metadata) != 2 {
+		t.Errorf("got %d metadata records, wanted %d", len(metadata), 2)
+	}
+}
+
+func TestNewLogReader(t *testing.T) {
+	for _, fixture := range []string{
+		"../model/test_data/input_invocation_logs.json",
+		"../model/test_data/input_invocation_logs.json.gz",
+	} {
+		input, err := os.Open(fixture)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		logReader, err := newLogReader(input)
+		if err != nil {
+			t.Fatalf("%s: %v", fixture, err)
+		}
+
+		var lines []string
+		scanner := bufio.NewScanner(logReader)
+		for scanner.Scan() {
+			lines = append(lines, scanner.Text())
+		}
+		input.Close()
+
+		if err := scanner.Err(); err != nil {
+			t.Fatalf("%s: %v", fixture, err)
+		}
+
+		if len(lines) != 2 {
+			t.Fatalf("%s: got %d lines, wanted %d", fixture, len(lines), 2)
+		}
+
+		if lines[0][0] != '{' {
+			t.Errorf("%s: got %q, wanted a JSON object", fixture, lines[0][:1])
+		}
+	}
+}
+
+func TestNewLogReaderEmpty(t *testing.T) {
+	input, err := os.Open(os.DevNull)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer input.Close()
+
+	logReader, err := newLogReader(input)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	scanner := bufio.NewScanner(logReader)
+	if scanner.Scan() {
+		t.Errorf("got %q, wanted no lines", scanner.Text())
+	}
+}
+
+type testBatchJobResolver struct{}
+
+func (testBatchJobResolver) GetBatchJob(jobID string) (*model.BatchJob, error) {
+	return &model.BatchJob{
+		JobArn:  "arn:aws:bedrock:us-east-1:893487256304:model-invocation-job/" + jobID,
+		ModelID: "anthropic.claude-3-haiku-20240307-v1:0",
+		EndTime: time.Date(2024, 5, 1, 10, 42, 0, 0, time.UTC),
+	}, nil
+}
+
+func TestProcessModelInvocationLogObjectsBatchOutput(t *testing.T) {
+	modelMetaDataGenerator := newTestMetadataGenerator(t, model.GeneratorOptions{BatchJobs: testBatchJobResolver{}})
+
+	key := "batch/output/abcd1234efgh/records.jsonl.jsonl.out"
+	logStore := newMemoryStore()
+	logStore.objects[key] = []byte(`{"recordId": "CALL0000001", "modelInput": {}, "modelOutput": {"usage": {"input_tokens": 1000, "output_tokens": 100}}}
+{"recordId": "CALL0000002", "modelInput": {}, "error": {"errorCode": 400, "errorMessage": "Malformed input request"}}
+`)
+
+	metadataStore := newMemoryStore()
+
+	outputOptions := output.Options{Layout: output.LayoutHive}
+	modelLogsProcessor := NewProcessor(logStore, metadataStore, nil, modelMetaDataGenerator, outputOptions)
+	report, err := modelLogsProcessor.ProcessModelInvocationLogObjects([]storage.ObjectInfo{{Key: key}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if report.LinesParsed != 1 || report.LinesSkipped != 1 {
+		t.Errorf("got %+v, wanted 1 parsed and 1 skipped line", report.Counts)
+	}
+
+	outputKey := "account=893487256304/region=us-east-1/year=2024/month=05/day=01/hour=10/abcd1234efgh/records.jsonl.json.gz"
+	body, ok := metadataStore.objects[outputKey]
+	if !ok {
+		t.Fatalf("missing metadata object %q", outputKey)
+	}
+
+	metadata := readMetadata(t, body)
+	if len(metadata) != 1 {
+		t.Fatalf("got %d records, wanted %d", len(metadata), 1)
+	}
+	if metadata[0].PricingMode != model.PricingModeBatch {
+		t.Errorf("got %q, wanted %q", metadata[0].PricingMode, model.PricingModeBatch)
+	}
+	if metadata[0].InputTokenCostUSD != 0.000125 {
+		t.Errorf("got %f, wanted %f", metadata[0].InputTokenCostUSD, 0.000125)
+	}
+}
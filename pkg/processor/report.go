@@ -0,0 +1,107 @@
+package processor
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// reportKeyPrefix is where run reports are written in the metadata sink. Athena skips
+// prefixes starting with an underscore, so reports do not pollute metadata queries.
+const reportKeyPrefix = "_reports"
+
+// Counts tallies objects, log lines and their estimated cost for an object, an hour
+// or a whole run.
+type Counts struct {
+	ObjectsListed    int     `json:"objectsListed"`
+	ObjectsSkipped   int     `json:"objectsSkipped"`
+	ObjectsSucceeded int     `json:"objectsSucceeded"`
+	ObjectsFailed    int     `json:"objectsFailed"`
+	LinesParsed      int     `json:"linesParsed"`
+	LinesSkipped     int     `json:"linesSkipped"`
+	TotalCostUSD     float64 `json:"totalCostUSD"`
+}
+
+func (c *Counts) add(other Counts) {
+	c.ObjectsListed += other.ObjectsListed
+	c.ObjectsSkipped += other.ObjectsSkipped
+	c.ObjectsSucceeded += other.ObjectsSucceeded
+	c.ObjectsFailed += other.ObjectsFailed
+	c.LinesParsed += other.LinesParsed
+	c.LinesSkipped += other.LinesSkipped
+	c.TotalCostUSD += other.TotalCostUSD
+}
+
+// HourSummary counts the objects and log lines processed for a single hour
+type HourSummary struct {
+	Hour time.Time `json:"hour"`
+	Counts
+	Failures []ObjectFailure `json:"-"`
+}
+
+type ObjectFailure struct {
+	Key   string `json:"key"`
+	Error string `json:"error"`
+}
+
+// RunReport summarizes a processing run. It is written as JSON next to the metadata
+// output and turned into an error when any object failed.
+type RunReport struct {
+	StartedAt  time.Time `json:"startedAt"`
+	FinishedAt time.Time `json:"finishedAt"`
+	Counts
+	Hours    []*HourSummary  `json:"hours,omitempty"`
+	Failures []ObjectFailure `json:"failures,omitempty"`
+}
+
+func newRunReport() *RunReport {
+	return &RunReport{StartedAt: time.Now().UTC()}
+}
+
+func (r *RunReport) addHour(summary *HourSummary) {
+	r.Counts.add(summary.Counts)
+	r.Hours = append(r.Hours, summary)
+	r.Failures = append(r.Failures, summary.Failures...)
+}
+
+func (r *RunReport) addObject(key string, counts Counts, err error) {
+	r.Counts.add(counts)
+	if err != nil {
+		r.Failures = append(r.Failures, ObjectFailure{Key: key, Error: err.Error()})
+	}
+}
+
+// ObjectsFailedError is returned by a run that completed with failed objects, which
+// are listed in the failures of its report
+type ObjectsFailedError struct {
+	Failed    int
+	Processed int
+}
+
+func (e *ObjectsFailedError) Error() string {
+	return fmt.Sprintf("failed to process %d of %d objects", e.Failed, e.Processed)
+}
+
+// Err returns an error when any object of the run failed
+func (r *RunReport) Err() error {
+	if r.ObjectsFailed == 0 {
+		return nil
+	}
+	return &ObjectsFailedError{Failed: r.ObjectsFailed, Processed: r.ObjectsListed - r.ObjectsSkipped}
+}
+
+func (p *Processor) writeRunReport(report *RunReport) error {
+	report.FinishedAt = time.Now().UTC()
+
+	body, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	key := fmt.Sprintf("%s/%s/run-%s.json", reportKeyPrefix, report.StartedAt.Format("2006/01/02"), report.StartedAt.Format("20060102T150405.000000000Z"))
+	if err := p.metadataSink.WriteObject(key, bytes.NewReader(body), "application/json", ""); err != nil {
+		return fmt.Errorf("unable to write run report, %v", err)
+	}
+	return nil
+}
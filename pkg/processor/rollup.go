@@ -0,0 +1,125 @@
+package processor
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/greenscale-ai/amazon-bedrock-metadata/pkg/model"
+	"github.com/greenscale-ai/amazon-bedrock-metadata/pkg/output"
+)
+
+const (
+	provisionedThroughputRollupKeyPrefix = "_rollups/provisioned-throughput"
+	duplicatePromptRollupKeyPrefix       = "_rollups/duplicate-prompts"
+)
+
+// rollupHour identifies the model invocation logs of an hour
+type rollupHour struct {
+	inputPrefix string
+	accountID   string
+	region      string
+	hour        time.Time
+}
+
+// rollup writes the rollups of an hour. They read back all metadata written for the
+// hour rather than only that of this run, so they stay complete when objects are
+// skipped or arrive late, and rewriting them is idempotent. The metadata is
+// aggregated one object at a time, so the hour is never held in memory.
+func (p *Processor) rollup(h rollupHour) error {
+	var provisionedThroughput *model.ProvisionedThroughputAggregator
+	if p.modelInvocation.ProvisionedThroughputEnabled() {
+		provisionedThroughput = p.modelInvocation.NewProvisionedThroughputAggregator(h.accountID, h.region, h.hour)
+	}
+	var duplicatePrompts *model.DuplicatePromptAggregator
+	if p.modelInvocation.FingerprintsEnabled() {
+		duplicatePrompts = model.NewDuplicatePromptAggregator(h.accountID, h.region, h.hour)
+	}
+	if p.metadataStore == nil || (provisionedThroughput == nil && duplicatePrompts == nil) {
+		return nil
+	}
+
+	objects, err := p.metadataStore.ListObjects(p.output.HourPrefix(h.inputPrefix, h.accountID, h.region, h.hour))
+	if err != nil {
+		return err
+	}
+
+	for _, obj := range objects {
+		metadata, err := p.readMetadataObject(obj.Key)
+		if err != nil {
+			return err
+		}
+		for i := range metadata {
+			if provisionedThroughput != nil {
+				provisionedThroughput.Add(&metadata[i])
+			}
+			if duplicatePrompts != nil {
+				duplicatePrompts.Add(&metadata[i])
+			}
+		}
+	}
+
+	if provisionedThroughput != nil {
+		if err := p.rollupProvisionedThroughput(h, provisionedThroughput); err != nil {
+			return err
+		}
+	}
+	if duplicatePrompts != nil {
+		if err := p.rollupDuplicatePrompts(h, duplicatePrompts); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// rollupProvisionedThroughput amortizes the hour's provisioned throughput cost across
+// its invocations
+func (p *Processor) rollupProvisionedThroughput(h rollupHour, aggregator *model.ProvisionedThroughputAggregator) error {
+	rollups := aggregator.Rollups()
+	if len(rollups) == 0 {
+		return nil
+	}
+
+	if err := p.writeRollup(provisionedThroughputRollupKeyPrefix, h, rollups); err != nil {
+		return fmt.Errorf("unable to write provisioned throughput rollup, %v", err)
+	}
+	return nil
+}
+
+// rollupDuplicatePrompts reports the hour's repeated prompts and their cost
+func (p *Processor) rollupDuplicatePrompts(h rollupHour, aggregator *model.DuplicatePromptAggregator) error {
+	rollup := aggregator.Rollup()
+	if rollup == nil {
+		return nil
+	}
+
+	if err := p.writeRollup(duplicatePromptRollupKeyPrefix, h, rollup); err != nil {
+		return fmt.Errorf("unable to write duplicate prompt rollup, %v", err)
+	}
+	return nil
+}
+
+func (p *Processor) writeRollup(keyPrefix string, h rollupHour, rollup any) error {
+	body, err := json.MarshalIndent(rollup, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	key := fmt.Sprintf("%s/%s/%s/%s.json", keyPrefix, h.accountID, h.region, h.hour.Format("2006/01/02/15"))
+	return p.metadataSink.WriteObject(key, bytes.NewReader(body), "application/json", "")
+}
+
+func (p *Processor) readMetadataObject(key string) ([]model.InvocationLogMetadata, error) {
+	body, err := p.metadataStore.OpenObject(key)
+	if err != nil {
+		return nil, err
+	}
+	defer body.Close()
+
+	metadata, err := output.ReadMetadata(key, body)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read metadata object %q, %v", key, err)
+	}
+	return metadata, nil
+}
@@ -0,0 +1,236 @@
+package processor
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/greenscale-ai/amazon-bedrock-metadata/pkg/model"
+	"github.com/greenscale-ai/amazon-bedrock-metadata/pkg/output"
+)
+
+const testProvisionedModelArn = "arn:aws:bedrock:us-west-2:893487256304:provisioned-model/a1b2c3d4e5f6"
+
+func newTestProvisionedMetadataGenerator(t *testing.T) *model.MetadataGenerator {
+	t.Helper()
+
+	provisionedModels, err := model.NewProvisionedModels([]byte(`{"` + testProvisionedModelArn + `": {"model_id": "meta.llama2-13b-chat-v1", "model_units": 1, "commitment": "6-month"}}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return newTestMetadataGenerator(t, model.GeneratorOptions{ProvisionedModels: provisionedModels})
+}
+
+// provisionedLogs returns a log object with the given number of invocations of the
+// provisioned model
+func provisionedLogs(t *testing.T, invocations int) []byte {
+	t.Helper()
+
+	input, err := os.ReadFile("../model/test_data/input_invoke.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var invocationLog map[string]any
+	if err := json.Unmarshal(input, &invocationLog); err != nil {
+		t.Fatal(err)
+	}
+	invocationLog["modelId"] = testProvisionedModelArn
+	// No IAM lookups for the identity
+	invocationLog["identity"] = map[string]any{"arn": ""}
+
+	record, err := json.Marshal(invocationLog)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var logs bytes.Buffer
+	for i := 0; i < invocations; i++ {
+		logs.Write(record)
+		logs.WriteByte('\n')
+	}
+	return logs.Bytes()
+}
+
+func readRollups(t *testing.T, store *memoryStore, key string) []model.ProvisionedThroughputRollup {
+	t.Helper()
+
+	body, ok := store.objects[key]
+	if !ok {
+		t.Fatalf("missing rollup %q", key)
+	}
+
+	var rollups []model.ProvisionedThroughputRollup
+	if err := json.Unmarshal(body, &rollups); err != nil {
+		t.Fatal(err)
+	}
+	return rollups
+}
+
+func TestRollupProvisionedThroughput(t *testing.T) {
+	hourPrefix := "AWSLogs/893487256304/BedrockModelInvocationLogs/us-west-2/2024/03/05/20/"
+	rollupKey := "_rollups/provisioned-throughput/893487256304/us-west-2/2024/03/05/20.json"
+
+	logStore := newMemoryStore()
+	logStore.objects[hourPrefix+"first.json"] = provisionedLogs(t, 3)
+
+	metadataStore := newMemoryStore()
+	checkpointStore := &memoryCheckpointStore{}
+
+	modelLogsProcessor := NewProcessor(logStore, metadataStore, checkpointStore, newTestProvisionedMetadataGenerator(t), output.Options{Format: output.FormatParquet})
+	start := time.Date(2024, 3, 5, 20, 0, 0, 0, time.UTC)
+	if _, err := modelLogsProcessor.ProcessModelInvocationLogsInRange("893487256304", "us-west-2", "", start, start.Add(time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+
+	rollups := readRollups(t, metadataStore, rollupKey)
+	if len(rollups) != 1 || rollups[0].Invocations != 3 {
+		t.Fatalf("got %+v, wanted one rollup with 3 invocations", rollups)
+	}
+
+	if rollups[0].HourlyCostUSD != 13.08 {
+		t.Errorf("got %f, wanted %f", rollups[0].HourlyCostUSD, 13.08)
+	}
+
+	// A late object is processed on its own by an event invocation, which leaves the
+	// rollup to the next scheduled run
+	logStore.objects[hourPrefix+"late.json"] = provisionedLogs(t, 1)
+	listed, _ := logStore.ListObjects(hourPrefix + "late.json")
+	report, err := modelLogsProcessor.ProcessModelInvocationLogObjects(listed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.ObjectsSucceeded != 1 {
+		t.Fatalf("got %+v, wanted 1 succeeded object", report.Counts)
+	}
+
+	rollups = readRollups(t, metadataStore, rollupKey)
+	if len(rollups) != 1 || rollups[0].Invocations != 3 {
+		t.Fatalf("got %+v, wanted the rollup with 3 invocations unchanged", rollups)
+	}
+
+	// The scheduled run skips the late object, but still rolls up the whole hour
+	report, err = modelLogsProcessor.ProcessModelInvocationLogsInRange("893487256304", "us-west-2", "", start, start.Add(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.ObjectsSkipped != 2 {
+		t.Fatalf("got %+v, wanted 2 skipped objects", report.Counts)
+	}
+
+	rollups = readRollups(t, metadataStore, rollupKey)
+	if len(rollups) != 1 || rollups[0].Invocations != 4 {
+		t.Fatalf("got %+v, wanted one rollup with 4 invocations", rollups)
+	}
+
+	if rollups[0].CostPerInvocationUSD != 13.08/4 {
+		t.Errorf("got %f, wanted %f", rollups[0].CostPerInvocationUSD, 13.08/4)
+	}
+
+	// Once rolled up, the hour is not rolled up again
+	delete(metadataStore.objects, rollupKey)
+	if _, err := modelLogsProcessor.ProcessModelInvocationLogsInRange("893487256304", "us-west-2", "", start, start.Add(time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := metadataStore.objects[rollupKey]; ok {
+		t.Errorf("got rollup %q, wanted none", rollupKey)
+	}
+}
+
+func TestRollupDuplicatePrompts(t *testing.T) {
+	hourPrefix := "AWSLogs/893487256304/BedrockModelInvocationLogs/us-east-1/2024/09/12/14/"
+	rollupKey := "_rollups/duplicate-prompts/893487256304/us-east-1/2024/09/12/14.json"
+
+	modelMetaDataGenerator := newTestMetadataGenerator(t, model.GeneratorOptions{Fingerprints: model.NewFingerprinter("pepper", model.NormalizeWhitespace)})
+
+	input, err := os.ReadFile("../model/test_data/input_converse.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var invocationLog map[string]any
+	if err := json.Unmarshal(input, &invocationLog); err != nil {
+		t.Fatal(err)
+	}
+	// No IAM lookups for the identity
+	invocationLog["identity"] = map[string]any{"arn": ""}
+	record, err := json.Marshal(invocationLog)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	logStore := newMemoryStore()
+	logStore.objects[hourPrefix+"first.json"] = bytes.Join([][]byte{record, record, record}, []byte("\n"))
+	metadataStore := newMemoryStore()
+
+	modelLogsProcessor := NewProcessor(logStore, metadataStore, &memoryCheckpointStore{}, modelMetaDataGenerator, output.Options{Format: output.FormatJSON})
+	if _, err := modelLogsProcessor.ProcessModelInvocationLogs("893487256304", "us-east-1", "", 2024, 9, 12, 14); err != nil {
+		t.Fatal(err)
+	}
+
+	body, ok := metadataStore.objects[rollupKey]
+	if !ok {
+		t.Fatalf("missing rollup %q", rollupKey)
+	}
+	var rollup model.DuplicatePromptRollup
+	if err := json.Unmarshal(body, &rollup); err != nil {
+		t.Fatal(err)
+	}
+
+	if rollup.Invocations != 3 || rollup.DuplicateInvocations != 2 || len(rollup.Groups) != 1 {
+		t.Fatalf("got %+v, wanted 3 invocations with 2 duplicates in one group", rollup)
+	}
+	if rollup.DuplicateCostUSD <= 0 || rollup.DuplicateCostUSD >= rollup.CostUSD {
+		t.Errorf("got %f duplicate cost of %f, wanted two thirds", rollup.DuplicateCostUSD, rollup.CostUSD)
+	}
+
+	// Without provisioned models there is no provisioned throughput rollup
+	for key := range metadataStore.objects {
+		if strings.HasPrefix(key, "_rollups/provisioned-throughput/") {
+			t.Errorf("got unexpected rollup %q", key)
+		}
+	}
+}
+
+func TestRollupOnlyProcessedHours(t *testing.T) {
+	hourPrefix := "AWSLogs/893487256304/BedrockModelInvocationLogs/us-west-2/2024/03/05/"
+	rollupKey := "_rollups/provisioned-throughput/893487256304/us-west-2/2024/03/05/20.json"
+
+	logStore := newMemoryStore()
+	logStore.objects[hourPrefix+"20/first.json"] = provisionedLogs(t, 2)
+
+	metadataStore := newMemoryStore()
+	checkpointStore := &memoryCheckpointStore{}
+	metadataGenerator := newTestProvisionedMetadataGenerator(t)
+
+	run := func() {
+		modelLogsProcessor := NewProcessor(logStore, metadataStore, checkpointStore, metadataGenerator, output.Options{})
+		start := time.Date(2024, 3, 5, 20, 0, 0, 0, time.UTC)
+		if _, err := modelLogsProcessor.ProcessModelInvocationLogsInRange("893487256304", "us-west-2", "", start, start.Add(2*time.Hour)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	run()
+	for _, key := range []string{rollupKey, strings.Replace(rollupKey, "20.json", "21.json", 1)} {
+		if _, ok := metadataStore.objects[key]; !ok {
+			t.Fatalf("missing rollup %q", key)
+		}
+	}
+
+	// Hours without processed objects, here the idle hour 21 and the already processed
+	// hour 20, are not rolled up again
+	for key := range metadataStore.objects {
+		if strings.HasPrefix(key, provisionedThroughputRollupKeyPrefix) {
+			delete(metadataStore.objects, key)
+		}
+	}
+	run()
+	for key := range metadataStore.objects {
+		if strings.HasPrefix(key, provisionedThroughputRollupKeyPrefix) {
+			t.Errorf("got rollup %q, wanted none", key)
+		}
+	}
+}
@@ -0,0 +1,170 @@
+package storage
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// LocalLogSource reads model invocation logs from a directory laid out like the
+// S3 bucket, e.g. a local copy made with `aws s3 sync`.
+type LocalLogSource struct {
+	root string
+}
+
+func NewLocalLogSource(root string) *LocalLogSource {
+	return &LocalLogSource{root: root}
+}
+
+func (l *LocalLogSource) ListObjects(prefix string) ([]ObjectInfo, error) {
+	// Only walk the deepest directory fully covered by the prefix
+	walkRoot := filepath.Join(l.root, filepath.FromSlash(path.Dir(prefix)))
+
+	var objects []ObjectInfo
+	err := filepath.WalkDir(walkRoot, func(filePath string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		if entry.IsDir() {
+			return nil
+		}
+
+		relPath, err := filepath.Rel(l.root, filePath)
+		if err != nil {
+			return err
+		}
+		key := filepath.ToSlash(relPath)
+		if !strings.HasPrefix(key, prefix) {
+			return nil
+		}
+
+		info, err := entry.Info()
+		if err != nil {
+			return err
+		}
+		objects = append(objects, ObjectInfo{
+			Key:  key,
+			ETag: fmt.Sprintf("%x-%x", info.ModTime().UnixNano(), info.Size()),
+			Size: info.Size(),
+		})
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error listing objects in directory %q: %w", l.root, err)
+	}
+
+	return objects, nil
+}
+
+func (l *LocalLogSource) OpenObject(key string) (io.ReadCloser, error) {
+	return os.Open(filepath.Join(l.root, filepath.FromSlash(key)))
+}
+
+// LocalMetadataSink writes metadata objects below a local directory, using the
+// object key as the relative file path. It reads them back like a LocalLogSource.
+type LocalMetadataSink struct {
+	*LocalLogSource
+	root string
+}
+
+func NewLocalMetadataSink(root string) *LocalMetadataSink {
+	return &LocalMetadataSink{LocalLogSource: NewLocalLogSource(root), root: root}
+}
+
+func (l *LocalMetadataSink) WriteObject(key string, body io.Reader, contentType, contentEncoding string) error {
+	filePath := filepath.Join(l.root, filepath.FromSlash(key))
+	if err := os.MkdirAll(filepath.Dir(filePath), 0o755); err != nil {
+		return err
+	}
+
+	// Write to a temporary file in the same directory and rename it once complete, so a
+	// failed write never leaves a truncated object
+	file, err := os.CreateTemp(filepath.Dir(filePath), "."+filepath.Base(filePath)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	tmpPath := file.Name()
+
+	if _, err := io.Copy(file, body); err != nil {
+		file.Close()
+		os.Remove(tmpPath)
+		return fmt.Errorf("unable to write object %q to directory %q, %v", key, l.root, err)
+	}
+	if err := file.Chmod(0o644); err != nil {
+		file.Close()
+		os.Remove(tmpPath)
+		return err
+	}
+	if err := file.Close(); err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+	if err := os.Rename(tmpPath, filePath); err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+	return nil
+}
+
+// LocalCheckpointStore keeps the checkpoint manifest in a local state file. Its
+// version is a digest of the file, checked under a lock that covers the runs of a
+// single process.
+type LocalCheckpointStore struct {
+	mu   sync.Mutex
+	path string
+}
+
+func NewLocalCheckpointStore(path string) *LocalCheckpointStore {
+	return &LocalCheckpointStore{path: path}
+}
+
+func (l *LocalCheckpointStore) LoadCheckpoint() ([]byte, string, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return l.load()
+}
+
+func (l *LocalCheckpointStore) load() ([]byte, string, error) {
+	body, err := os.ReadFile(l.path)
+	if os.IsNotExist(err) {
+		return nil, "", nil
+	}
+	if err != nil {
+		return nil, "", err
+	}
+	digest := sha256.Sum256(body)
+	return body, hex.EncodeToString(digest[:]), nil
+}
+
+func (l *LocalCheckpointStore) SaveCheckpoint(body []byte, version string) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if _, savedVersion, err := l.load(); err != nil {
+		return err
+	} else if savedVersion != version {
+		return ErrCheckpointConflict
+	}
+
+	if err := os.MkdirAll(filepath.Dir(l.path), 0o755); err != nil {
+		return err
+	}
+
+	// Write to a temporary file first so an interrupted run never leaves a truncated checkpoint
+	tmpPath := l.path + ".tmp"
+	if err := os.WriteFile(tmpPath, body, 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmpPath, l.path)
+}
@@ -0,0 +1,140 @@
+package storage
+
+import (
+	"errors"
+	"io"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestLocalStorage(t *testing.T) {
+	root := t.TempDir()
+	sink := NewLocalMetadataSink(root)
+
+	keys := []string{
+		"AWSLogs/123456789012/BedrockModelInvocationLogs/us-east-1/2024/03/05/20/a.json.gz",
+		"AWSLogs/123456789012/BedrockModelInvocationLogs/us-east-1/2024/03/05/20/b.json.gz",
+		"AWSLogs/123456789012/BedrockModelInvocationLogs/us-east-1/2024/03/05/21/c.json.gz",
+	}
+	for _, key := range keys {
+		if err := sink.WriteObject(key, strings.NewReader(key), "application/json", "gzip"); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	source := NewLocalLogSource(root)
+	objects, err := source.ListObjects("AWSLogs/123456789012/BedrockModelInvocationLogs/us-east-1/2024/03/05/20")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(objects) != 2 {
+		t.Fatalf("got %d objects, wanted %d", len(objects), 2)
+	}
+
+	for i, obj := range objects {
+		if obj.Key != keys[i] {
+			t.Errorf("got %q, wanted %q", obj.Key, keys[i])
+		}
+
+		body, err := source.OpenObject(obj.Key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		content, _ := io.ReadAll(body)
+		body.Close()
+
+		if string(content) != keys[i] {
+			t.Errorf("got %q, wanted %q", content, keys[i])
+		}
+	}
+
+	objects, err = source.ListObjects("AWSLogs/123456789012/BedrockModelInvocationLogs/us-east-1/2024/03/06/00")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(objects) != 0 {
+		t.Errorf("got %d objects, wanted %d", len(objects), 0)
+	}
+}
+
+// failingReader returns some content, then fails like a broken pipe
+type failingReader struct {
+	content io.Reader
+}
+
+func (r *failingReader) Read(p []byte) (int, error) {
+	n, err := r.content.Read(p)
+	if err == io.EOF {
+		return n, errors.New("pipe failed")
+	}
+	return n, err
+}
+
+func TestLocalMetadataSink_WriteObjectFailed(t *testing.T) {
+	root := t.TempDir()
+	sink := NewLocalMetadataSink(root)
+
+	prefix := "AWSLogs/123456789012/BedrockModelInvocationLogs/us-east-1/2024/03/05/20/"
+	if err := sink.WriteObject(prefix+"a.json.gz", &failingReader{strings.NewReader("partial")}, "application/json", "gzip"); err == nil {
+		t.Fatal("got no error, wanted an error for the failed body")
+	}
+
+	if _, err := sink.OpenObject(prefix + "a.json.gz"); !os.IsNotExist(err) {
+		t.Errorf("got %v, wanted no object", err)
+	}
+	entries, err := os.ReadDir(root + "/" + prefix)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 0 {
+		t.Errorf("got %d files, wanted %d", len(entries), 0)
+	}
+
+	// A failed write leaves a previously written object as it was
+	if err := sink.WriteObject(prefix+"b.json.gz", strings.NewReader("complete"), "application/json", "gzip"); err != nil {
+		t.Fatal(err)
+	}
+	if err := sink.WriteObject(prefix+"b.json.gz", &failingReader{strings.NewReader("partial")}, "application/json", "gzip"); err == nil {
+		t.Fatal("got no error, wanted an error for the failed body")
+	}
+	content, err := os.ReadFile(root + "/" + prefix + "b.json.gz")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(content) != "complete" {
+		t.Errorf("got %q, wanted %q", content, "complete")
+	}
+}
+
+func TestLocalCheckpointStore(t *testing.T) {
+	store := NewLocalCheckpointStore(t.TempDir() + "/state/checkpoint.json")
+
+	body, version, err := store.LoadCheckpoint()
+	if err != nil || body != nil || version != "" {
+		t.Fatalf("got %q, %q, %v, wanted no checkpoint", body, version, err)
+	}
+
+	if err := store.SaveCheckpoint([]byte("first"), ""); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.SaveCheckpoint([]byte("second"), ""); !errors.Is(err, ErrCheckpointConflict) {
+		t.Errorf("got %v, wanted %v", err, ErrCheckpointConflict)
+	}
+
+	body, version, err = store.LoadCheckpoint()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(body) != "first" {
+		t.Errorf("got %q, wanted %q", body, "first")
+	}
+	if err := store.SaveCheckpoint([]byte("second"), version); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.SaveCheckpoint([]byte("third"), version); !errors.Is(err, ErrCheckpointConflict) {
+		t.Errorf("got %v, wanted %v", err, ErrCheckpointConflict)
+	}
+}
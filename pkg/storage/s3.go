@@ -0,0 +1,161 @@
+package storage
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3manager"
+	"io"
+	"net/http"
+)
+
+type S3LogSource struct {
+	s3Client *s3.S3
+	bucket   string
+}
+
+func NewS3LogSource(s3Client *s3.S3, bucket string) *S3LogSource {
+	return &S3LogSource{
+		s3Client: s3Client,
+		bucket:   bucket,
+	}
+}
+
+func (s *S3LogSource) ListObjects(prefix string) ([]ObjectInfo, error) {
+	params := &s3.ListObjectsV2Input{
+		Bucket: aws.String(s.bucket),
+		Prefix: aws.String(prefix),
+	}
+
+	var objects []ObjectInfo
+	err := s.s3Client.ListObjectsV2Pages(params, func(output *s3.ListObjectsV2Output, lastPage bool) bool {
+		for _, obj := range output.Contents {
+			objects = append(objects, ObjectInfo{
+				Key:  aws.StringValue(obj.Key),
+				ETag: aws.StringValue(obj.ETag),
+				Size: aws.Int64Value(obj.Size),
+			})
+		}
+		return true
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error listing objects in bucket %q: %w", s.bucket, err)
+	}
+
+	return objects, nil
+}
+
+func (s *S3LogSource) OpenObject(key string) (io.ReadCloser, error) {
+	result, err := s.s3Client.GetObject(&s3.GetObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("unable to get object %q from bucket %q, %v", key, s.bucket, err)
+	}
+	return result.Body, nil
+}
+
+// uploadConcurrency bounds the parts buffered in memory per upload
+const uploadConcurrency = 2
+
+// S3MetadataSink streams metadata objects to S3 with the multipart upload manager, so
+// memory use is bounded by the part size regardless of the object size. It reads the
+// metadata objects back like an S3LogSource.
+type S3MetadataSink struct {
+	*S3LogSource
+	uploader *s3manager.Uploader
+}
+
+func NewS3MetadataSink(s3Client *s3.S3, bucket string) *S3MetadataSink {
+	return &S3MetadataSink{
+		S3LogSource: NewS3LogSource(s3Client, bucket),
+		uploader: s3manager.NewUploaderWithClient(s3Client, func(u *s3manager.Uploader) {
+			u.PartSize = s3manager.MinUploadPartSize
+			u.Concurrency = uploadConcurrency
+		}),
+	}
+}
+
+func (s *S3MetadataSink) WriteObject(key string, body io.Reader, contentType, contentEncoding string) error {
+	input := &s3manager.UploadInput{
+		Bucket:      aws.String(s.bucket),
+		Key:         aws.String(key),
+		Body:        body,
+		ContentType: aws.String(contentType),
+	}
+	if contentEncoding != "" {
+		input.ContentEncoding = aws.String(contentEncoding)
+	}
+
+	_, err := s.uploader.Upload(input)
+	if err != nil {
+		return fmt.Errorf("unable to upload object %q to bucket %q, %v", key, s.bucket, err)
+	}
+	return nil
+}
+
+// S3CheckpointStore keeps the checkpoint manifest as a single object, normally in
+// the metadata output bucket.
+type S3CheckpointStore struct {
+	s3Client *s3.S3
+	bucket   string
+	key      string
+}
+
+func NewS3CheckpointStore(s3Client *s3.S3, bucket, key string) *S3CheckpointStore {
+	return &S3CheckpointStore{
+		s3Client: s3Client,
+		bucket:   bucket,
+		key:      key,
+	}
+}
+
+func (s *S3CheckpointStore) LoadCheckpoint() ([]byte, string, error) {
+	result, err := s.s3Client.GetObject(&s3.GetObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(s.key),
+	})
+	if err != nil {
+		var awsErr awserr.Error
+		if errors.As(err, &awsErr) && awsErr.Code() == s3.ErrCodeNoSuchKey {
+			return nil, "", nil
+		}
+		return nil, "", fmt.Errorf("unable to get checkpoint %q from bucket %q, %v", s.key, s.bucket, err)
+	}
+	defer result.Body.Close()
+
+	body, err := io.ReadAll(result.Body)
+	if err != nil {
+		return nil, "", err
+	}
+	return body, aws.StringValue(result.ETag), nil
+}
+
+// SaveCheckpoint uses an S3 conditional write: If-Match the ETag of the loaded
+// checkpoint, or If-None-Match for a checkpoint that did not exist yet
+func (s *S3CheckpointStore) SaveCheckpoint(body []byte, version string) error {
+	req, _ := s.s3Client.PutObjectRequest(&s3.PutObjectInput{
+		Bucket:      aws.String(s.bucket),
+		Key:         aws.String(s.key),
+		Body:        bytes.NewReader(body),
+		ContentType: aws.String("application/json"),
+	})
+	if version != "" {
+		req.HTTPRequest.Header.Set("If-Match", version)
+	} else {
+		req.HTTPRequest.Header.Set("If-None-Match", "*")
+	}
+
+	if err := req.Send(); err != nil {
+		var requestErr awserr.RequestFailure
+		if errors.As(err, &requestErr) && (requestErr.StatusCode() == http.StatusPreconditionFailed || requestErr.StatusCode() == http.StatusConflict) {
+			return ErrCheckpointConflict
+		}
+		return fmt.Errorf("unable to upload checkpoint %q to bucket %q, %v", s.key, s.bucket, err)
+	}
+	return nil
+}
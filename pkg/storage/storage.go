@@ -0,0 +1,43 @@
+package storage
+
+import (
+	"errors"
+	"io"
+)
+
+type ObjectInfo struct {
+	Key  string
+	ETag string
+	Size int64
+}
+
+// LogSource lists and reads model invocation log objects.
+type LogSource interface {
+	ListObjects(prefix string) ([]ObjectInfo, error)
+	OpenObject(key string) (io.ReadCloser, error)
+}
+
+// MetadataSink stores the generated metadata objects.
+type MetadataSink interface {
+	WriteObject(key string, body io.Reader, contentType, contentEncoding string) error
+}
+
+// MetadataStore is a MetadataSink that can also list and read back the metadata
+// objects, as rollups over an hour of metadata need.
+type MetadataStore interface {
+	MetadataSink
+	LogSource
+}
+
+// CheckpointStore persists the checkpoint manifest between runs. LoadCheckpoint
+// returns nil when no checkpoint has been saved yet, along with the version of the
+// checkpoint. SaveCheckpoint only replaces the checkpoint of the given version, or
+// creates it for an empty version, and returns ErrCheckpointConflict when another
+// run or invocation saved it meanwhile.
+type CheckpointStore interface {
+	LoadCheckpoint() (body []byte, version string, err error)
+	SaveCheckpoint(body []byte, version string) error
+}
+
+// ErrCheckpointConflict is returned when the checkpoint was saved concurrently
+var ErrCheckpointConflict = errors.New("checkpoint was saved concurrently")
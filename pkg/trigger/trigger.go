@@ -0,0 +1,145 @@
+package trigger
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/aws/aws-lambda-go/events"
+	"log"
+	"strings"
+	"time"
+)
+
+// ObjectRef is an S3 object referenced by an event. MessageID is set when the
+// object was delivered through SQS, so failures can be reported per message.
+type ObjectRef struct {
+	Bucket    string
+	Key       string
+	ETag      string
+	MessageID string
+}
+
+// Invocation describes what a Lambda invocation asks to process: either a scheduled
+// batch run or the objects referenced by S3 notifications.
+type Invocation struct {
+	Scheduled bool
+	// Time is the time of the EventBridge scheduled event, zero when not scheduled by EventBridge
+	Time    time.Time
+	FromSQS bool
+	Objects []ObjectRef
+	// InvalidMessageIDs are SQS messages whose body could not be parsed
+	InvalidMessageIDs []string
+}
+
+type envelope struct {
+	Records    []json.RawMessage `json:"Records"`
+	DetailType string            `json:"detail-type"`
+	Source     string            `json:"source"`
+	Detail     json.RawMessage   `json:"detail"`
+}
+
+type record struct {
+	EventSource string `json:"eventSource"`
+	EventName   string `json:"eventName"`
+}
+
+type objectCreatedDetail struct {
+	Bucket struct {
+		Name string `json:"name"`
+	} `json:"bucket"`
+	Object struct {
+		Key  string `json:"key"`
+		ETag string `json:"etag"`
+	} `json:"object"`
+}
+
+// Parse recognizes S3 event notifications, delivered directly, wrapped in SQS messages
+// or as EventBridge "Object Created" events, and EventBridge scheduled events. An empty
+// payload is also treated as a scheduled batch run; any other payload is an error, so
+// an unexpected event never starts a batch run.
+func Parse(payload []byte) (*Invocation, error) {
+	if len(bytes.TrimSpace(payload)) == 0 {
+		return &Invocation{Scheduled: true}, nil
+	}
+
+	invocation := &Invocation{}
+	var sqsEvent events.SQSEvent
+	if err := json.Unmarshal(payload, &sqsEvent); err == nil && len(sqsEvent.Records) > 0 && sqsEvent.Records[0].EventSource == "aws:sqs" {
+		invocation.FromSQS = true
+		for _, message := range sqsEvent.Records {
+			objects, isObjectEvent, err := parseObjects([]byte(message.Body))
+			if err == nil && !isObjectEvent {
+				err = errors.New("not an S3 event notification")
+			}
+			if err != nil {
+				log.Printf("Error parsing SQS message: %s, error:%v\n", message.MessageId, err)
+				invocation.InvalidMessageIDs = append(invocation.InvalidMessageIDs, message.MessageId)
+				continue
+			}
+			for _, obj := range objects {
+				obj.MessageID = message.MessageId
+				invocation.Objects = append(invocation.Objects, obj)
+			}
+		}
+		return invocation, nil
+	}
+
+	objects, isObjectEvent, err := parseObjects(payload)
+	if err != nil {
+		return nil, err
+	}
+	if isObjectEvent {
+		invocation.Objects = objects
+		return invocation, nil
+	}
+
+	var scheduledEvent events.CloudWatchEvent
+	if err := json.Unmarshal(payload, &scheduledEvent); err != nil || scheduledEvent.DetailType != "Scheduled Event" {
+		return nil, fmt.Errorf("unsupported event %.200s", payload)
+	}
+	invocation.Scheduled = true
+	invocation.Time = scheduledEvent.Time
+	return invocation, nil
+}
+
+// parseObjects returns the objects referenced by an S3 or EventBridge event, and
+// whether the payload was such an event at all.
+func parseObjects(payload []byte) ([]ObjectRef, bool, error) {
+	var e envelope
+	if err := json.Unmarshal(payload, &e); err != nil {
+		return nil, false, err
+	}
+
+	if e.Source == "aws.s3" && e.DetailType == "Object Created" {
+		var detail objectCreatedDetail
+		if err := json.Unmarshal(e.Detail, &detail); err != nil {
+			return nil, true, err
+		}
+		return []ObjectRef{{Bucket: detail.Bucket.Name, Key: detail.Object.Key, ETag: detail.Object.ETag}}, true, nil
+	}
+
+	var objects []ObjectRef
+	for _, rawRecord := range e.Records {
+		var r record
+		if err := json.Unmarshal(rawRecord, &r); err != nil {
+			return nil, true, err
+		}
+		// Only created objects are processed, not removed or restored ones
+		if r.EventSource != "aws:s3" || !strings.HasPrefix(r.EventName, "ObjectCreated:") {
+			continue
+		}
+
+		var s3Record events.S3EventRecord
+		if err := json.Unmarshal(rawRecord, &s3Record); err != nil {
+			return nil, true, err
+		}
+		objects = append(objects, ObjectRef{Bucket: s3Record.S3.Bucket.Name, Key: s3Record.S3.Object.URLDecodedKey, ETag: s3Record.S3.Object.ETag})
+	}
+
+	// S3 test events sent when a notification is configured carry no records
+	var testEvent events.S3TestEvent
+	isTestEvent := json.Unmarshal(payload, &testEvent) == nil && testEvent.Event == "s3:TestEvent"
+
+	return objects, e.Records != nil || isTestEvent, nil
+}
@@ -0,0 +1,217 @@
+package trigger
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/aws/aws-lambda-go/events"
+	"strings"
+	"testing"
+	"time"
+)
+
+const s3Event = `{
+  "Records": [
+    {
+      "eventVersion": "2.1",
+      "eventSource": "aws:s3",
+      "awsRegion": "us-east-1",
+      "eventName": "ObjectCreated:Put",
+      "s3": {
+        "bucket": {"name": "bedrock-logs"},
+        "object": {"key": "AWSLogs/123456789012/BedrockModelInvocationLogs/us-east-1/2024/03/05/20/20240305T2035Z_a+b%3Dc.json.gz", "eTag": "abc"}
+      }
+    }
+  ]
+}`
+
+const eventBridgeObjectCreatedEvent = `{
+  "version": "0",
+  "id": "17793124-05d4-b198-2fde-7ededc63b103",
+  "detail-type": "Object Created",
+  "source": "aws.s3",
+  "account": "123456789012",
+  "time": "2024-03-05T20:36:12Z",
+  "region": "us-east-1",
+  "resources": ["arn:aws:s3:::bedrock-logs"],
+  "detail": {
+    "bucket": {"name": "bedrock-logs"},
+    "object": {"key": "AWSLogs/123456789012/BedrockModelInvocationLogs/us-east-1/2024/03/05/20/b.json.gz"}
+  }
+}`
+
+const scheduledEvent = `{
+  "version": "0",
+  "id": "53dc4d37-cffa-4f76-80c9-8b7d4a4d2eaa",
+  "detail-type": "Scheduled Event",
+  "source": "aws.events",
+  "account": "123456789012",
+  "time": "2024-03-05T21:00:00Z",
+  "region": "us-east-1",
+  "resources": ["arn:aws:events:us-east-1:123456789012:rule/hourly"],
+  "detail": {}
+}`
+
+func sqsEvent(t *testing.T, bodies ...string) string {
+	var sqsEvent events.SQSEvent
+	for i, body := range bodies {
+		sqsEvent.Records = append(sqsEvent.Records, events.SQSMessage{
+			MessageId:   fmt.Sprintf("message-%d", i),
+			EventSource: "aws:sqs",
+			Body:        body,
+		})
+	}
+
+	payload, err := json.Marshal(sqsEvent)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(payload)
+}
+
+func TestParseS3Event(t *testing.T) {
+	invocation, err := Parse([]byte(s3Event))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if invocation.Scheduled || invocation.FromSQS {
+		t.Errorf("got scheduled=%t fromSQS=%t, wanted false and false", invocation.Scheduled, invocation.FromSQS)
+	}
+
+	if len(invocation.Objects) != 1 {
+		t.Fatalf("got %d objects, wanted %d", len(invocation.Objects), 1)
+	}
+
+	// keys in S3 notifications are URL encoded
+	wantedKey := "AWSLogs/123456789012/BedrockModelInvocationLogs/us-east-1/2024/03/05/20/20240305T2035Z_a b=c.json.gz"
+	if invocation.Objects[0].Key != wantedKey {
+		t.Errorf("got %q, wanted %q", invocation.Objects[0].Key, wantedKey)
+	}
+
+	if invocation.Objects[0].Bucket != "bedrock-logs" {
+		t.Errorf("got %q, wanted %q", invocation.Objects[0].Bucket, "bedrock-logs")
+	}
+
+	if invocation.Objects[0].ETag != "abc" {
+		t.Errorf("got %q, wanted %q", invocation.Objects[0].ETag, "abc")
+	}
+}
+
+func TestParseEventBridgeObjectCreatedEvent(t *testing.T) {
+	invocation, err := Parse([]byte(eventBridgeObjectCreatedEvent))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if invocation.Scheduled || len(invocation.Objects) != 1 {
+		t.Fatalf("got scheduled=%t and %d objects, wanted false and 1", invocation.Scheduled, len(invocation.Objects))
+	}
+
+	if invocation.Objects[0].Key != "AWSLogs/123456789012/BedrockModelInvocationLogs/us-east-1/2024/03/05/20/b.json.gz" {
+		t.Errorf("got %q", invocation.Objects[0].Key)
+	}
+}
+
+func TestParseSQSEvent(t *testing.T) {
+	invocation, err := Parse([]byte(sqsEvent(t, s3Event, eventBridgeObjectCreatedEvent, "not json", `{"Service":"Amazon S3","Event":"s3:TestEvent","Bucket":"bedrock-logs"}`)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if invocation.Scheduled || !invocation.FromSQS {
+		t.Errorf("got scheduled=%t fromSQS=%t, wanted false and true", invocation.Scheduled, invocation.FromSQS)
+	}
+
+	if len(invocation.Objects) != 2 {
+		t.Fatalf("got %d objects, wanted %d", len(invocation.Objects), 2)
+	}
+
+	if invocation.Objects[0].MessageID != "message-0" || invocation.Objects[1].MessageID != "message-1" {
+		t.Errorf("got %q and %q, wanted %q and %q", invocation.Objects[0].MessageID, invocation.Objects[1].MessageID, "message-0", "message-1")
+	}
+
+	if len(invocation.InvalidMessageIDs) != 1 || invocation.InvalidMessageIDs[0] != "message-2" {
+		t.Errorf("got %v, wanted %v", invocation.InvalidMessageIDs, []string{"message-2"})
+	}
+}
+
+func TestParseScheduledEvent(t *testing.T) {
+	for _, payload := range []string{scheduledEvent, ""} {
+		invocation, err := Parse([]byte(payload))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if !invocation.Scheduled {
+			t.Errorf("%q: got scheduled=%t, wanted true", payload, invocation.Scheduled)
+		}
+	}
+}
+
+func TestParseScheduledEventTime(t *testing.T) {
+	invocation, err := Parse([]byte(scheduledEvent))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !invocation.Time.Equal(time.Date(2024, 3, 5, 21, 0, 0, 0, time.UTC)) {
+		t.Errorf("got %s, wanted %s", invocation.Time, "2024-03-05T21:00:00Z")
+	}
+
+	invocation, err = Parse(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !invocation.Time.IsZero() {
+		t.Errorf("got %s, wanted zero time", invocation.Time)
+	}
+}
+
+func TestParseS3TestEvent(t *testing.T) {
+	invocation, err := Parse([]byte(`{"Service":"Amazon S3","Event":"s3:TestEvent","Bucket":"bedrock-logs"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if invocation.Scheduled || len(invocation.Objects) != 0 {
+		t.Errorf("got scheduled=%t and %d objects, wanted false and 0", invocation.Scheduled, len(invocation.Objects))
+	}
+}
+
+func TestParseUnsupportedEvent(t *testing.T) {
+	objectDeletedEvent := strings.Replace(eventBridgeObjectCreatedEvent, "Object Created", "Object Deleted", 1)
+	for _, payload := range []string{"{}", objectDeletedEvent, `{"detail-type": "Other Event", "source": "aws.events"}`} {
+		if _, err := Parse([]byte(payload)); err == nil {
+			t.Errorf("%q: expected an error", payload)
+		}
+	}
+
+	invocation, err := Parse([]byte(sqsEvent(t, objectDeletedEvent)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(invocation.Objects) != 0 || len(invocation.InvalidMessageIDs) != 1 {
+		t.Errorf("got %d objects and invalid messages %v, wanted none and %v", len(invocation.Objects), invocation.InvalidMessageIDs, []string{"message-0"})
+	}
+}
+
+func TestParseS3EventSkipsRemovedObjects(t *testing.T) {
+	removed := strings.Replace(s3Event, "ObjectCreated:Put", "ObjectRemoved:Delete", 1)
+	invocation, err := Parse([]byte(removed))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if invocation.Scheduled || len(invocation.Objects) != 0 {
+		t.Errorf("got scheduled=%t and %d objects, wanted false and 0", invocation.Scheduled, len(invocation.Objects))
+	}
+
+	invocation, err = Parse([]byte(sqsEvent(t, removed, s3Event)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(invocation.Objects) != 1 || invocation.Objects[0].MessageID != "message-1" {
+		t.Errorf("got %+v, wanted the created object of message-1", invocation.Objects)
+	}
+}